@@ -24,7 +24,6 @@ type spritesheet struct {
 	sprite_width      int
 	amount_of_sprites []int
 	hframes           int
-	sprite_resize_px  int
 }
 type folderInfo struct {
 	sub_folder_path         string
@@ -46,15 +45,57 @@ func main() {
 	sprite_source_folder := flag.String("f", "", "Folder name that contains sprites.")
 	image_path := flag.String("i", "", "Image path for resizing single image (requires -sr flag)")
 	hframes := flag.Int("hf", 8, "Horizontal Frames: Amount of horizontal frames you want in your spritesheet: default 8.")
-	sprite_resize_px := flag.Int("sr", 0, "Sprite Resize: Resize each sprite to the pixel value provided.")
+	sprite_resize := flag.String("sr", "", "Sprite Resize: Resize each sprite to the pixel value provided, e.g. 64 or 64x32 (width x height).")
+	resize_mode := flag.String("rm", "stretch", "Resize Mode: 'stretch' (default), 'fit' inside WxH, 'fill' WxH and crop, 'pad' fit and centre on a transparent WxH canvas")
+	resize_filter := flag.String("rf", "lanczos3", "Resize Filter: nearest, bilinear, bicubic, mitchell, lanczos2, lanczos3 (default), 'pixel' for whole-factor nearest-neighbour pixel art scaling, or the pixel art upscalers scalex, hqlike (an hqNx approximation), xbr")
+	linear_resize := flag.Bool("linear", false, "Linear Resize: Resample in linear light with premultiplied alpha to avoid dark fringes on soft edges (not with -rf nearest or pixel)")
+	scales := flag.String("scales", "", "Scales: Comma separated scale factors, e.g. 1,2,3 writes name_f<frames>_v<vframes>@1x.png, @2x and @3x spritesheets from one decode")
+	write_metadata := flag.Bool("meta", false, "Metadata: Write a <spritesheet>.json with the frame rects next to each spritesheet")
 	fade_amount := flag.Int("fade", 0, "Fade Amount: Apply fading to edges (0-100, where 0=no fade, 50=half radius fade, 100=full radius fade)")
-	fade_mode := flag.String("fm", "c", "Fade Mode: 'c' for circle (default), 's' for square")
+	fade_mode := flag.String("fm", "c", "Fade Mode: 'c' for circle (default), 's' for square, 'e' for an ellipse following the sprite's aspect ratio, 'r' for a rounded rectangle, 'l' for a one-sided linear gradient (see -fd, -frange), 'm' for a mask image (see -fmask)")
+	fade_direction := flag.String("fd", "down", "Fade Direction: With -fm l, direction the sprite fades out towards: up, down (default), left, right or an angle in degrees (0=right, 90=down)")
+	fade_range := flag.String("frange", "", "Fade Range: With -fm l, start,end percentages along -fd where the fade begins and is fully transparent, e.g. 50,100 (default: the last -fade percent)")
+	fade_mask := flag.String("fmask", "", "Fade Mask: Grayscale mask image stretched to each sprite and multiplied into its alpha (white keeps, black fades out), selects -fm m")
+	outline_width := flag.Int("outline", 0, "Outline: Stroke this many px around the non-transparent pixels of each sprite (0 = no outline)")
+	outline_color := flag.String("oc", "ffffff", "Outline Colour: Hex colour of the -outline stroke, rrggbb or rrggbbaa")
+	outline_position := flag.String("op", "outside", "Outline Position: outside, inside or center of the sprite's edge; outside and center grow each sprite's canvas")
+	outline_antialias := flag.Bool("oaa", false, "Outline Anti-aliasing: Smooth the -outline edge instead of hard pixel steps")
+	shadow_offset := flag.String("shadow", "", "Drop Shadow: Offset X,Y in px of a drop shadow behind each sprite, e.g. 4,4 (negative values go left/up)")
+	shadow_blur := flag.Int("sblur", 0, "Shadow Blur: Blur radius of the -shadow in px")
+	shadow_color := flag.String("sc", "000000", "Shadow Colour: Hex colour of the -shadow, rrggbb or rrggbbaa")
+	shadow_opacity := flag.Int("so", 50, "Shadow Opacity: Opacity of the -shadow (0-100)")
+	glow_radius := flag.Int("glow", 0, "Outer Glow: Radius in px of a glow around each sprite (0 = no glow)")
+	glow_color := flag.String("gc", "ffffff", "Glow Colour: Hex colour of the -glow, rrggbb or rrggbbaa")
+	glow_opacity := flag.Int("go", 100, "Glow Opacity: Opacity of the -glow (0-100)")
+	recolor_source := flag.String("recolor", "", "Recolour: Source palette to swap, a palette image or hex list e.g. b22222,8b0000")
+	recolor_targets := flag.String("palettes", "", "Palettes: Target palettes for -recolor separated by ';', each a palette image or hex list, optionally named e.g. \"blue=1e90ff,00008b;yellow.png\"")
+	recolor_tolerance := flag.Int("rtol", 0, "Recolour Tolerance: Also swap colours within this RGB distance of a source colour, keeping their shading (0 = exact matches only)")
+	fade_easing := flag.String("fe", "linear", "Fade Easing: Falloff curve across the fade: linear (default), smoothstep, quadratic, cubic, exponential or gaussian")
+	fade_corner_radius := flag.Int("fr", 25, "Fade Corner Radius: With -fm r, corner radius as a percentage of the shorter half side (0-100)")
 	single_sprites := flag.Bool("ss", false, "Single Sprites: Output sprites rather than spritesheet use with -sr flag")
 	cpu_threads := flag.Int("t", 0, "CPU threads available (default max available)")
-	cut_spritesheet := flag.String("x", "", "Example: -x 128x128. Cut spritesheet into size individual sprites. ")
+	cut_spritesheet := flag.String("x", "", "Example: -x 128x128. Cut spritesheet into size individual sprites. -x auto reads the grid and frame count from gontage's <name>_f<frames>_v<vframes> names or -meta json. -x detect finds sprites on irregular sheets. -x atlas.json|.atlas|.xml unpacks a TexturePacker, LibGDX or Starling atlas.")
+	cut_margin := flag.Int("margin", 0, "Margin: With -x, outer margin in px around the cells of the spritesheet")
+	cut_spacing := flag.Int("spacing", 0, "Spacing: With -x, gutter in px between cells")
+	cut_offset := flag.String("offset", "", "Offset: With -x, X,Y position in px of the cell grid, e.g. 4,2")
+	cut_grid := flag.String("grid", "", "Grid: Cut into columns x rows (e.g. 8x4) instead of -x WxH, cell size is worked out from the sheet size")
+	cut_background := flag.String("bg", "", "Background: With -x, rrggbb colour key treated as background like transparency (made transparent in the cut sprites)")
+	merge_distance := flag.Int("merge", 0, "Merge: With -x detect, merge sprites whose bounding boxes are at most this many px apart")
+	name_template := flag.String("name", "", "Name: Output name template for -x and -ss, e.g. {name}_{row}_{col} or {index:04} (placeholders {name}, {index}, {row}, {col}, :N zero pads to N digits)")
+	frame_order := flag.String("order", "natural", "Order: Frame order for -f and each -mf sub folder: 'natural' (default, frame2 before frame10), 'lexical', 'mtime', or an order list file with one file name per line")
+	include_files := flag.String("include", "", "Include: Only use source images whose file name matches one of these comma separated globs, or regexes prefixed with re:, e.g. walk_*.png,re:^idle_\\d+")
+	exclude_files := flag.String("exclude", "", "Exclude: Skip source images whose file name matches one of these comma separated globs or re: regexes. Non-image and hidden files are always skipped")
+	skip_empty := flag.Bool("skip-empty", false, "Skip Empty: With -x, skip cells that are fully transparent or only the background colour (the -bg colour key, or else the colour of the sheet's four corners when they match)")
 	parent_folder_path := flag.String("mf", "", "Multiple Folders: path should be parent folder containing sub folders that contain folders with sprites/images in them. Refer to test_multi for example structure.")
 	useMontage := flag.Bool("montage", false, "Use montage with -mf instead of gontage (if installed)")
 	fix_png_checksum := flag.Bool("fix-png", false, "Fix PNG checksum errors by re-encoding the image")
+	output_format := flag.String("of", "", "Output Format: png, jpeg, tga, bmp, gif or qoi (default png, -ss/-i keep unfaded JPGs as JPG)")
+	png_compression := flag.String("pc", "speed", "PNG Compression: none, speed, default or best")
+	jpeg_quality := flag.Int("q", 100, "JPEG Quality: 1-100")
+	palette_colors := flag.Int("colors", 0, "Palette Colors: Quantize PNG/GIF output to an indexed image with this many colors (2-256, 0=off)")
+	quantizer := flag.String("quant", "median", "Quantizer: 'median' for median cut (default), 'octree' for octree")
+	optimize_png := flag.Bool("optimize", false, "Optimize PNG: Losslessly try colour type/bit depth reductions, filters and compression levels, keep the smallest (slower)")
+	dither := flag.String("dither", "none", "Dither: 'none' (default), 'fs' for Floyd-Steinberg, 'ordered' for 8x8 Bayer")
 	help := flag.Bool("h", false, "Display help")
 	showVersion := flag.Bool("v", false, "Display version")
 	flag.Parse()
@@ -69,17 +110,78 @@ func main() {
 		fmt.Printf("gontage %s\n", version)
 		os.Exit(0)
 	}
+	sprite_resize_width, sprite_resize_height, err := gontage.ParseResizeSize(*sprite_resize)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	sprite_scales, err := gontage.ParseScales(*scales)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 	gontage_args := gontage.GontageArgs{
-		Sprite_source_folder:    filepath.Clean(*sprite_source_folder),
-		Image_path:              filepath.Clean(*image_path),
-		Hframes:                 *hframes,
-		Sprite_resize_px_resize: *sprite_resize_px,
-		Fade_amount:             *fade_amount,
-		Fade_mode:               *fade_mode,
-		Single_sprites:          *single_sprites,
-		Cut_spritesheet:         *cut_spritesheet,
-		Cpu_threads:             *cpu_threads,
-		Fix_png_checksum:        *fix_png_checksum,
+		Sprite_source_folder: filepath.Clean(*sprite_source_folder),
+		Image_path:           filepath.Clean(*image_path),
+		Hframes:              *hframes,
+		Sprite_resize_width:  sprite_resize_width,
+		Sprite_resize_height: sprite_resize_height,
+		Resize_mode:          *resize_mode,
+		Resize_filter:        *resize_filter,
+		Linear_resize:        *linear_resize,
+		Fade_amount:          *fade_amount,
+		Fade_mode:            *fade_mode,
+		Fade_corner_radius:   *fade_corner_radius,
+		Fade_easing:          *fade_easing,
+		Fade_direction:       *fade_direction,
+		Fade_range:           *fade_range,
+		Fade_mask:            *fade_mask,
+		Outline_width:        *outline_width,
+		Outline_color:        *outline_color,
+		Outline_position:     *outline_position,
+		Outline_antialias:    *outline_antialias,
+		Shadow_offset:        *shadow_offset,
+		Shadow_blur:          *shadow_blur,
+		Shadow_color:         *shadow_color,
+		Shadow_opacity:       *shadow_opacity,
+		Glow_radius:          *glow_radius,
+		Glow_color:           *glow_color,
+		Glow_opacity:         *glow_opacity,
+		Recolor_source:       *recolor_source,
+		Recolor_targets:      *recolor_targets,
+		Recolor_tolerance:    *recolor_tolerance,
+		Single_sprites:       *single_sprites,
+		Cut_spritesheet:      *cut_spritesheet,
+		Cpu_threads:          *cpu_threads,
+		Fix_png_checksum:     *fix_png_checksum,
+		Output_format:        *output_format,
+		Png_compression:      *png_compression,
+		Jpeg_quality:         *jpeg_quality,
+		Palette_colors:       *palette_colors,
+		Quantizer:            *quantizer,
+		Dither:               *dither,
+		Optimize_png:         *optimize_png,
+		Scales:               sprite_scales,
+		Write_metadata:       *write_metadata,
+		Skip_empty:           *skip_empty,
+		Cut_margin:           *cut_margin,
+		Cut_spacing:          *cut_spacing,
+		Cut_offset:           *cut_offset,
+		Cut_grid:             *cut_grid,
+		Cut_background:       *cut_background,
+		Merge_distance:       *merge_distance,
+		Name_template:        *name_template,
+		Frame_order:          *frame_order,
+		Include_files:        *include_files,
+		Exclude_files:        *exclude_files,
+	}
+	if err := gontage.ValidateOutputOptions(gontage_args); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if err := gontage.ValidateSourceFilters(gontage_args); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
 	}
 	if *image_path != "" {
 		gontage.ResizeSingleImage(gontage_args)
@@ -105,13 +207,12 @@ func main() {
 					sprite_source_folder:    *sprite_source_folder,
 				}
 				amount_of_sprites, folder_names, sprite_height, sprite_width :=
-					iterate_folder(sub_folder_path, *fix_png_checksum)
+					iterate_folder(sub_folder_path, gontage_args)
 				spritesheet := spritesheet{
 					sprite_height:     sprite_height,
 					sprite_width:      sprite_width,
 					amount_of_sprites: amount_of_sprites,
 					hframes:           *hframes,
-					sprite_resize_px:  *sprite_resize_px,
 				}
 				cli := cliOptions{
 					useMontage: *useMontage,
@@ -151,22 +252,19 @@ func call_gontage_or_montage(i int, spritesheet spritesheet, folder folderInfo,
 		}
 		fmt.Println(string(out), filepath.Join(folder.sub_folder_path_gontage, folder.folder_name)+"/*", sprite_name)
 	} else {
-		gontage_args := gontage.GontageArgs{
-			Sprite_source_folder:    filepath.Join(folder.sub_folder_path_gontage, folder.folder_name),
-			Hframes:                 spritesheet.hframes,
-			Sprite_resize_px_resize: spritesheet.sprite_resize_px,
-			Fade_amount:             gargs.Fade_amount,
-			Fade_mode:               gargs.Fade_mode,
-			Single_sprites:          false,
-			Cut_spritesheet:         "",
-			Cpu_threads:             gargs.Cpu_threads,
-			Fix_png_checksum:        gargs.Fix_png_checksum,
-		}
+		// Start from the CLI args so encoder and effect options carry over to every sub folder.
+		gontage_args := gargs
+		gontage_args.Sprite_source_folder = filepath.Join(folder.sub_folder_path_gontage, folder.folder_name)
+		gontage_args.Image_path = ""
+		gontage_args.Hframes = spritesheet.hframes
+		gontage_args.Single_sprites = false
+		gontage_args.Cut_spritesheet = ""
+		gontage_args.Cut_grid = ""
 		gontage.Gontage(gontage_args)
 	}
 }
 
-func iterate_folder(file_path_to_walk string, fixPngChecksum bool) ([]int, []string, int, int) {
+func iterate_folder(file_path_to_walk string, gargs gontage.GontageArgs) ([]int, []string, int, int) {
 	is_first_sprite_in_directory := true
 	folder_names := []string{}
 	amount_of_sprites := []int{}
@@ -184,16 +282,16 @@ func iterate_folder(file_path_to_walk string, fixPngChecksum bool) ([]int, []str
 				if err != nil {
 					log.Fatalf(err.Error())
 				}
-				amount_of_sprites = append(amount_of_sprites, len(folder_path))
+				amount_of_sprites = append(amount_of_sprites, len(gontage.FilterSourceFiles(folder_path, gargs)))
 				folder_names = append(folder_names, info.Name())
 			}
-			if !info.IsDir() && is_first_sprite_in_directory {
+			if !info.IsDir() && is_first_sprite_in_directory && gontage.IsSourceFile(info.Name(), gargs) {
 				if reader, err := os.Open(path); err == nil {
 					m, _, err := image.Decode(reader)
 					if err != nil {
 						reader.Close()
 						// Try to fix PNG checksum errors if enabled and file is PNG
-						if fixPngChecksum && strings.ToLower(filepath.Ext(info.Name())) == ".png" {
+						if gargs.Fix_png_checksum && strings.ToLower(filepath.Ext(info.Name())) == ".png" {
 							if fixErr := gontage.FixPngChecksum(path); fixErr != nil {
 								log.Fatalf("Failed to fix PNG checksum for %s: %v (original error: %v)", path, fixErr, err)
 							}
@@ -0,0 +1,446 @@
+package gontage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// atlasRegion is one named frame of a packed atlas, normalised from whichever format described it.
+type atlasRegion struct {
+	name string
+	// page is the atlas image the region is packed into, relative to the metadata file.
+	page string
+	// rect is the area the region takes up in the page, as stored (i.e. after rotation).
+	rect image.Rectangle
+	// rotation is how many degrees clockwise the region was turned when packed: 0, 90, 180 or 270.
+	rotation int
+	// offset is where the (trimmed) region goes on its original canvas of source_size.
+	offset      image.Point
+	source_size image.Point
+}
+
+// isAtlasFile reports whether -x names an atlas metadata file rather than a cell size.
+func isAtlasFile(cut_spritesheet string) bool {
+	switch strings.ToLower(filepath.Ext(cut_spritesheet)) {
+	case ".json", ".atlas", ".xml":
+		return true
+	}
+	return false
+}
+
+// atlasPath finds the -x metadata file, either as given or inside the -f folder.
+func atlasPath(gargs GontageArgs) (string, error) {
+	if _, err := os.Stat(gargs.Cut_spritesheet); err == nil {
+		return gargs.Cut_spritesheet, nil
+	}
+	in_folder := filepath.Join(gargs.Sprite_source_folder, gargs.Cut_spritesheet)
+	if _, err := os.Stat(in_folder); err != nil {
+		return "", fmt.Errorf("atlas metadata %q not found", gargs.Cut_spritesheet)
+	}
+	return in_folder, nil
+}
+
+// parseAtlas reads a TexturePacker JSON (hash or array), gontage -meta JSON, LibGDX .atlas or Starling/Sparrow XML file.
+func parseAtlas(path string) ([]atlasRegion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseTexturePackerJson(data)
+	case ".xml":
+		return parseStarlingXml(data)
+	default:
+		return parseLibgdxAtlas(data)
+	}
+}
+
+type texturePackerRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type texturePackerFrame struct {
+	Filename         string            `json:"filename"`
+	Frame            texturePackerRect `json:"frame"`
+	Rotated          bool              `json:"rotated"`
+	SpriteSourceSize texturePackerRect `json:"spriteSourceSize"`
+	SourceSize       struct {
+		W int `json:"w"`
+		H int `json:"h"`
+	} `json:"sourceSize"`
+}
+
+// parseTexturePackerJson reads the JSON (Hash) and JSON (Array) exports. Frame sizes are unrotated and
+// rotated frames are stored turned 90 degrees clockwise.
+func parseTexturePackerJson(data []byte) ([]atlasRegion, error) {
+	var atlas struct {
+		// Image is set by gontage's own -meta json, which can be unpacked the same way.
+		Image  string          `json:"image"`
+		Frames json.RawMessage `json:"frames"`
+		Meta   struct {
+			Image string `json:"image"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &atlas); err != nil {
+		return nil, err
+	}
+	if len(atlas.Frames) == 0 {
+		return nil, fmt.Errorf("no frames in TexturePacker json")
+	}
+	if atlas.Image != "" {
+		var metadata sheetMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, err
+		}
+		var regions []atlasRegion
+		for _, frame := range metadata.Frames {
+			regions = append(regions, atlasRegion{
+				name:        frame.Name,
+				page:        metadata.Image,
+				rect:        image.Rect(frame.X, frame.Y, frame.X+frame.W, frame.Y+frame.H),
+				source_size: image.Pt(frame.W, frame.H),
+			})
+		}
+		return regions, nil
+	}
+	var frames []texturePackerFrame
+	if bytes.HasPrefix(bytes.TrimSpace(atlas.Frames), []byte("[")) {
+		if err := json.Unmarshal(atlas.Frames, &frames); err != nil {
+			return nil, err
+		}
+	} else {
+		var hash map[string]texturePackerFrame
+		if err := json.Unmarshal(atlas.Frames, &hash); err != nil {
+			return nil, err
+		}
+		for name, frame := range hash {
+			frame.Filename = name
+			frames = append(frames, frame)
+		}
+		// Map order is random, keep the output stable.
+		sort.Slice(frames, func(i, j int) bool { return frames[i].Filename < frames[j].Filename })
+	}
+
+	var regions []atlasRegion
+	for _, frame := range frames {
+		region := atlasRegion{
+			name:        frame.Filename,
+			page:        atlas.Meta.Image,
+			rect:        image.Rect(frame.Frame.X, frame.Frame.Y, frame.Frame.X+frame.Frame.W, frame.Frame.Y+frame.Frame.H),
+			offset:      image.Pt(frame.SpriteSourceSize.X, frame.SpriteSourceSize.Y),
+			source_size: image.Pt(frame.SourceSize.W, frame.SourceSize.H),
+		}
+		if frame.Rotated {
+			region.rotation = 90
+			region.rect.Max = region.rect.Min.Add(image.Pt(frame.Frame.H, frame.Frame.W))
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// parseStarlingXml reads Starling/Sparrow <TextureAtlas> files. Sizes are as stored in the atlas,
+// rotated regions are turned 90 degrees clockwise and frameX/frameY are negative trim offsets.
+func parseStarlingXml(data []byte) ([]atlasRegion, error) {
+	var atlas struct {
+		ImagePath   string `xml:"imagePath,attr"`
+		SubTextures []struct {
+			Name        string `xml:"name,attr"`
+			X           int    `xml:"x,attr"`
+			Y           int    `xml:"y,attr"`
+			Width       int    `xml:"width,attr"`
+			Height      int    `xml:"height,attr"`
+			FrameX      int    `xml:"frameX,attr"`
+			FrameY      int    `xml:"frameY,attr"`
+			FrameWidth  int    `xml:"frameWidth,attr"`
+			FrameHeight int    `xml:"frameHeight,attr"`
+			Rotated     bool   `xml:"rotated,attr"`
+		} `xml:"SubTexture"`
+	}
+	if err := xml.Unmarshal(data, &atlas); err != nil {
+		return nil, err
+	}
+	var regions []atlasRegion
+	for _, sub := range atlas.SubTextures {
+		region := atlasRegion{
+			name:   sub.Name,
+			page:   atlas.ImagePath,
+			rect:   image.Rect(sub.X, sub.Y, sub.X+sub.Width, sub.Y+sub.Height),
+			offset: image.Pt(-sub.FrameX, -sub.FrameY),
+		}
+		if sub.Rotated {
+			region.rotation = 90
+		}
+		region.source_size = image.Pt(sub.FrameWidth, sub.FrameHeight)
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// parseLibgdxAtlas reads both the old (xy/size/orig/offset) and new (bounds/offsets) LibGDX formats.
+// A blank line starts a new page, sizes are unrotated, rotate is counter-clockwise and offsets
+// are measured from the bottom-left of the original image.
+func parseLibgdxAtlas(data []byte) ([]atlasRegion, error) {
+	var regions []atlasRegion
+	var region *atlasRegion
+	page := ""
+	index := -1
+	// size holds the unrotated region size until the region is complete.
+	var size image.Point
+	var bottom_offset image.Point
+	finish := func() {
+		if region == nil {
+			return
+		}
+		if region.source_size == (image.Point{}) {
+			region.source_size = size
+		}
+		region.offset = image.Pt(bottom_offset.X, region.source_size.Y-bottom_offset.Y-size.Y)
+		region.rect.Max = region.rect.Min.Add(size)
+		if region.rotation == 90 || region.rotation == 270 {
+			region.rect.Max = region.rect.Min.Add(image.Pt(size.Y, size.X))
+		}
+		if index >= 0 {
+			region.name = fmt.Sprintf("%s_%d", region.name, index)
+		}
+		regions = append(regions, *region)
+		region = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			finish()
+			page = ""
+			continue
+		}
+		key, value, is_property := strings.Cut(line, ":")
+		if !is_property {
+			finish()
+			if page == "" {
+				page = line
+				continue
+			}
+			region = &atlasRegion{name: line, page: page}
+			index = -1
+			size, bottom_offset = image.Point{}, image.Point{}
+			continue
+		}
+		if region == nil {
+			// Page properties (size, format, filter, repeat) don't matter for unpacking.
+			continue
+		}
+		values, err := atlasInts(value)
+		switch strings.TrimSpace(key) {
+		case "rotate":
+			switch strings.TrimSpace(value) {
+			case "true":
+				region.rotation = 270
+			case "false":
+			default:
+				degrees, err := strconv.Atoi(strings.TrimSpace(value))
+				if err != nil || degrees%90 != 0 {
+					return nil, fmt.Errorf("unsupported rotate %q for %s", value, region.name)
+				}
+				region.rotation = (360 - degrees%360) % 360
+			}
+			continue
+		case "xy":
+			if err == nil && len(values) == 2 {
+				region.rect.Min = image.Pt(values[0], values[1])
+			}
+		case "size":
+			if err == nil && len(values) == 2 {
+				size = image.Pt(values[0], values[1])
+			}
+		case "bounds":
+			if err == nil && len(values) == 4 {
+				region.rect.Min = image.Pt(values[0], values[1])
+				size = image.Pt(values[2], values[3])
+			}
+		case "orig":
+			if err == nil && len(values) == 2 {
+				region.source_size = image.Pt(values[0], values[1])
+			}
+		case "offset":
+			if err == nil && len(values) == 2 {
+				bottom_offset = image.Pt(values[0], values[1])
+			}
+		case "offsets":
+			if err == nil && len(values) == 4 {
+				bottom_offset = image.Pt(values[0], values[1])
+				region.source_size = image.Pt(values[2], values[3])
+			}
+		case "index":
+			if err == nil && len(values) == 1 {
+				index = values[0]
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q for %s", strings.TrimSpace(key), value, region.name)
+		}
+	}
+	finish()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+func atlasInts(value string) ([]int, error) {
+	var ints []int
+	for _, part := range strings.Split(value, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, v)
+	}
+	return ints, nil
+}
+
+// extractRegion copies region out of page, turns it back upright and places it on its original canvas.
+func extractRegion(page image.Image, region atlasRegion) *image.NRGBA {
+	stored := region.rect.Add(page.Bounds().Min)
+	packed := image.NewNRGBA(image.Rect(0, 0, stored.Dx(), stored.Dy()))
+	drawNRGBA(packed, packed.Bounds(), page, stored.Min)
+	upright := rotateNRGBA(packed, (360-region.rotation)%360)
+
+	source_size := region.source_size
+	if source_size.X <= 0 || source_size.Y <= 0 {
+		source_size = upright.Bounds().Size()
+	}
+	canvas := image.NewNRGBA(image.Rect(0, 0, source_size.X, source_size.Y))
+	drawNRGBA(canvas, upright.Bounds().Add(region.offset), upright, image.Point{})
+	return canvas
+}
+
+// rotateNRGBA turns img clockwise by degrees, a multiple of 90.
+func rotateNRGBA(img *image.NRGBA, degrees int) *image.NRGBA {
+	if degrees == 0 {
+		return img
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	rotated_size := image.Pt(height, width)
+	if degrees == 180 {
+		rotated_size = image.Pt(width, height)
+	}
+	rotated := image.NewNRGBA(image.Rectangle{Max: rotated_size})
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var to image.Point
+			switch degrees {
+			case 90:
+				to = image.Pt(height-1-y, x)
+			case 180:
+				to = image.Pt(width-1-x, height-1-y)
+			case 270:
+				to = image.Pt(y, width-1-x)
+			}
+			copy(rotated.Pix[rotated.PixOffset(to.X, to.Y):rotated.PixOffset(to.X, to.Y)+4], img.Pix[img.PixOffset(x, y):img.PixOffset(x, y)+4])
+		}
+	}
+	return rotated
+}
+
+// atlasFrameName turns a region name into a file path inside the output folder, keeping sub folders
+// like "walk/0" but never escaping the folder. A -name template is applied to the file name.
+func atlasFrameName(name string, index int, gargs GontageArgs, output_format string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		parts = []string{"frame"}
+	}
+	parts[len(parts)-1] = expandName(gargs.Name_template, defaultResizedName, nameFields{name: parts[len(parts)-1], index: index})
+	return filepath.Join(parts...) + outputExtension(output_format, "")
+}
+
+// unpackAtlas restores the original frames described by the -x metadata file into a folder named after it.
+func unpackAtlas(gargs GontageArgs, start time.Time) {
+	metadata_path, err := atlasPath(gargs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	regions, err := parseAtlas(metadata_path)
+	if err != nil {
+		log.Fatalf("reading atlas %s: %v", metadata_path, err)
+	}
+	if len(regions) == 0 {
+		fmt.Println("Looks like atlas", metadata_path, "has no frames...")
+		return
+	}
+
+	pages := map[string]image.Image{}
+	for _, region := range regions {
+		if _, ok := pages[region.page]; ok {
+			continue
+		}
+		page_path := region.page
+		if page_path == "" {
+			// Older exports leave the image out, assume it sits next to the metadata with the same name.
+			page_path = strings.TrimSuffix(filepath.Base(metadata_path), filepath.Ext(metadata_path)) + ".png"
+		}
+		page, err := decodeImageFile(filepath.Join(filepath.Dir(metadata_path), page_path), gargs.Fix_png_checksum)
+		if err != nil {
+			log.Fatalf("decoding atlas page %s: %v", page_path, err)
+		}
+		pages[region.page] = page
+	}
+
+	output_format := sheetOutputFormat(gargs)
+	folder_name := strings.TrimSuffix(filepath.Base(metadata_path), filepath.Ext(metadata_path))
+	output_folder := filepath.Join(filepath.Dir(metadata_path), folder_name)
+	var unpack_wg sync.WaitGroup
+	for i, region := range regions {
+		unpack_wg.Add(1)
+		go func() {
+			defer unpack_wg.Done()
+			frame := extractRegion(pages[region.page], region)
+			if hasEffects(gargs) {
+				frame = applyEffects(frame, gargs)
+			}
+			if isFading(gargs) {
+				frame = applyFading(frame, gargs)
+			}
+			frame_output := filepath.Join(output_folder, atlasFrameName(region.name, i, gargs, output_format))
+			if err := os.MkdirAll(filepath.Dir(frame_output), 0755); err != nil {
+				log.Printf("failed to create %s: %v", filepath.Dir(frame_output), err)
+				return
+			}
+			f, err := os.Create(frame_output)
+			if err != nil {
+				panic(err)
+			}
+			if err = encodeImage(f, frame, output_format, gargs); err != nil {
+				log.Printf("failed to encode: %v", err)
+			}
+			f.Close()
+		}()
+	}
+	unpack_wg.Wait()
+	fmt.Println(metadata_path, ": unpacked", len(regions), "frames into", output_folder, "\n total time: ", time.Since(start))
+}
@@ -0,0 +1,103 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// atlasTestPixel is the colour of pixel x, y of frame id in the testdata atlases. Every pixel differs,
+// so a frame turned or shifted the wrong way can't match.
+func atlasTestPixel(x int, y int, id uint8) color.NRGBA {
+	return color.NRGBA{uint8(x*40 + 10), uint8(y*40 + 10), id, 255}
+}
+
+// atlasTestFrames are the original frames packed into the testdata atlases: "a" is trimmed from a
+// 6x4 canvas down to 4x2 at 1,1 and "b" is a 3x5 frame stored rotated. The TexturePacker and Starling
+// atlases share a page with b turned clockwise, the LibGDX ones a page with b turned counter-clockwise.
+func atlasTestFrames() map[string]*image.NRGBA {
+	a := testImage(6, 4, func(x, y int) color.NRGBA {
+		if x < 1 || x > 4 || y < 1 || y > 2 {
+			return color.NRGBA{}
+		}
+		return atlasTestPixel(x-1, y-1, 1)
+	})
+	b := testImage(3, 5, func(x, y int) color.NRGBA { return atlasTestPixel(x, y, 2) })
+	return map[string]*image.NRGBA{"a": a, "b": b}
+}
+
+func TestUnpackAtlasFormats(t *testing.T) {
+	want := atlasTestFrames()
+	for _, tc := range []struct {
+		path     string
+		rotation int
+	}{
+		{"testdata/atlas_hash.json", 90},
+		{"testdata/atlas_array.json", 90},
+		{"testdata/atlas_starling.xml", 90},
+		{"testdata/atlas_libgdx_old.atlas", 270},
+		{"testdata/atlas_libgdx_new.atlas", 270},
+	} {
+		regions, err := parseAtlas(tc.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		if len(regions) != 2 {
+			t.Fatalf("%s: got %d regions, want 2", tc.path, len(regions))
+		}
+		for _, region := range regions {
+			name := region.name[:len(region.name)-len(filepath.Ext(region.name))]
+			wanted, ok := want[name]
+			if !ok {
+				t.Fatalf("%s: unexpected region %q", tc.path, region.name)
+			}
+			if name == "b" && region.rotation != tc.rotation {
+				t.Errorf("%s: b rotation %d, want %d", tc.path, region.rotation, tc.rotation)
+			}
+			page, err := decodeImageFile(filepath.Join(filepath.Dir(tc.path), region.page), false)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.path, err)
+			}
+			frame := extractRegion(page, region)
+			if frame.Bounds() != wanted.Bounds() {
+				t.Errorf("%s: %s bounds %v, want %v", tc.path, name, frame.Bounds(), wanted.Bounds())
+				continue
+			}
+			for y := 0; y < wanted.Bounds().Dy(); y++ {
+				for x := 0; x < wanted.Bounds().Dx(); x++ {
+					if got, want := frame.NRGBAAt(x, y), wanted.NRGBAAt(x, y); got != want {
+						t.Errorf("%s: %s pixel %d,%d = %v, want %v", tc.path, name, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRotateNRGBA(t *testing.T) {
+	// A 2x1 image of red then blue, turned clockwise red ends up on top.
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	img.SetNRGBA(0, 0, red)
+	img.SetNRGBA(1, 0, blue)
+	for _, tc := range []struct {
+		degrees int
+		size    image.Point
+		red     image.Point
+		blue    image.Point
+	}{
+		{90, image.Pt(1, 2), image.Pt(0, 0), image.Pt(0, 1)},
+		{180, image.Pt(2, 1), image.Pt(1, 0), image.Pt(0, 0)},
+		{270, image.Pt(1, 2), image.Pt(0, 1), image.Pt(0, 0)},
+	} {
+		rotated := rotateNRGBA(img, tc.degrees)
+		if rotated.Bounds().Size() != tc.size {
+			t.Errorf("%d: size %v, want %v", tc.degrees, rotated.Bounds().Size(), tc.size)
+			continue
+		}
+		if rotated.NRGBAAt(tc.red.X, tc.red.Y) != red || rotated.NRGBAAt(tc.blue.X, tc.blue.Y) != blue {
+			t.Errorf("%d: red at %v and blue at %v expected in %v", tc.degrees, tc.red, tc.blue, rotated.Pix)
+		}
+	}
+}
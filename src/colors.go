@@ -0,0 +1,24 @@
+package gontage
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// parseHexColor parses "rrggbb" or "rrggbbaa", with or without a leading '#'.
+func parseHexColor(hex string) (color.NRGBA, error) {
+	hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q (expected rrggbb or rrggbbaa)", hex)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q (expected rrggbb or rrggbbaa)", hex)
+	}
+	if len(hex) == 6 {
+		value = value<<8 | 0xff
+	}
+	return color.NRGBA{uint8(value >> 24), uint8(value >> 16), uint8(value >> 8), uint8(value)}, nil
+}
@@ -0,0 +1,227 @@
+package gontage
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cutAuto is the -x value that derives the grid from gontage's own output.
+const cutAuto = "auto"
+
+// sheetNamePattern matches gontage spritesheet names: <name>_f<frames>_v<vframes>, optionally with a -scales suffix.
+var sheetNamePattern = regexp.MustCompile(`_f(\d+)_v(\d+)(@[0-9.]+x)?$`)
+
+// cutGrid describes where the cells of a spritesheet are.
+type cutGrid struct {
+	cell_width  int
+	cell_height int
+	// top-left of the first cell, relative to the sheet's top-left.
+	origin  image.Point
+	spacing int
+	// columns/rows of 0 mean as many as fit.
+	columns int
+	rows    int
+}
+
+// rects lists the cell rects row by row, stopping after frames cells (-1 for all).
+func (grid cutGrid) rects(bounds image.Rectangle, frames int) []image.Rectangle {
+	columns, rows := grid.columns, grid.rows
+	if columns == 0 {
+		columns = (bounds.Dx() - grid.origin.X + grid.spacing) / (grid.cell_width + grid.spacing)
+	}
+	if rows == 0 {
+		rows = (bounds.Dy() - grid.origin.Y + grid.spacing) / (grid.cell_height + grid.spacing)
+	}
+	var rects []image.Rectangle
+	for v := range rows {
+		for h := range columns {
+			if frames >= 0 && len(rects) == frames {
+				return rects
+			}
+			min := bounds.Min.Add(grid.origin).Add(image.Pt(h*(grid.cell_width+grid.spacing), v*(grid.cell_height+grid.spacing)))
+			rects = append(rects, image.Rectangle{min, min.Add(image.Pt(grid.cell_width, grid.cell_height))}.Intersect(bounds))
+		}
+	}
+	return rects
+}
+
+// cutRects returns the source rect of every frame to cut from sheet, in frame order.
+func cutRects(gargs GontageArgs, sheet image.Image, sheet_name string) ([]image.Rectangle, error) {
+	switch strings.ToLower(gargs.Cut_spritesheet) {
+	case cutAuto:
+		return autoCutRects(gargs, sheet, sheet_name)
+	case cutDetect:
+		key, err := backgroundKey(gargs)
+		if err != nil {
+			return nil, err
+		}
+		return detectSpriteRects(sheet, key, gargs.Merge_distance), nil
+	}
+	offset, err := parseOffset(gargs.Cut_offset)
+	if err != nil {
+		return nil, err
+	}
+	if gargs.Cut_margin < 0 || gargs.Cut_spacing < 0 {
+		return nil, fmt.Errorf("cut margin and spacing can't be negative")
+	}
+	grid := cutGrid{
+		origin:  offset.Add(image.Pt(gargs.Cut_margin, gargs.Cut_margin)),
+		spacing: gargs.Cut_spacing,
+	}
+	bounds := sheet.Bounds()
+	if gargs.Cut_grid != "" {
+		// Derive the cell size from the column and row count, with the margin on every side.
+		grid.columns, grid.rows, err = parseCellSize(gargs.Cut_grid)
+		if err != nil {
+			return nil, err
+		}
+		grid.cell_width = (bounds.Dx() - grid.origin.X - gargs.Cut_margin - (grid.columns-1)*grid.spacing) / grid.columns
+		grid.cell_height = (bounds.Dy() - grid.origin.Y - gargs.Cut_margin - (grid.rows-1)*grid.spacing) / grid.rows
+		if grid.cell_width <= 0 || grid.cell_height <= 0 {
+			return nil, fmt.Errorf("%s is too small for a %s grid with margin %d and spacing %d", sheet_name, gargs.Cut_grid, gargs.Cut_margin, gargs.Cut_spacing)
+		}
+	} else {
+		grid.cell_width, grid.cell_height, err = parseCellSize(gargs.Cut_spritesheet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return grid.rects(bounds, -1), nil
+}
+
+// backgroundKey is the -bg colour key, nil when the background is transparency.
+func backgroundKey(gargs GontageArgs) (*color.NRGBA, error) {
+	if gargs.Cut_background == "" {
+		return nil, nil
+	}
+	key, err := parseHexColor(gargs.Cut_background)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// parseOffset parses -offset values like "4,2".
+func parseOffset(offset string) (image.Point, error) {
+	if offset == "" {
+		return image.Point{}, nil
+	}
+	parts := strings.Split(offset, ",")
+	if len(parts) != 2 {
+		return image.Point{}, fmt.Errorf("invalid offset %q (expected X,Y e.g. 4,2)", offset)
+	}
+	x, err_x := strconv.Atoi(strings.TrimSpace(parts[0]))
+	y, err_y := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err_x != nil || err_y != nil || x < 0 || y < 0 {
+		return image.Point{}, fmt.Errorf("invalid offset %q (expected X,Y e.g. 4,2)", offset)
+	}
+	return image.Pt(x, y), nil
+}
+
+func parseCellSize(size string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(size), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size %q (expected e.g. 128x128)", size)
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid width in %q", size)
+	}
+	height, err := strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid height in %q", size)
+	}
+	return width, height, nil
+}
+
+// autoCutRects reads the frame rects from the sheet's -meta json when there is one,
+// otherwise it works the grid out from the _f<frames>_v<vframes> file name.
+func autoCutRects(gargs GontageArgs, sheet image.Image, sheet_name string) ([]image.Rectangle, error) {
+	if data, err := os.ReadFile(filepath.Join(gargs.Sprite_source_folder, metadataPath(sheet_name))); err == nil {
+		var metadata sheetMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("reading metadata for %s: %v", sheet_name, err)
+		}
+		var rects []image.Rectangle
+		for _, frame := range metadata.Frames {
+			min := sheet.Bounds().Min.Add(image.Pt(frame.X, frame.Y))
+			rects = append(rects, image.Rectangle{min, min.Add(image.Pt(frame.W, frame.H))})
+		}
+		return rects, nil
+	}
+
+	match := sheetNamePattern.FindStringSubmatch(strings.TrimSuffix(sheet_name, filepath.Ext(sheet_name)))
+	if match == nil {
+		return nil, fmt.Errorf("can't detect the grid of %s: expected a <name>_f<frames>_v<vframes> file name or a -meta json, use -x WxH instead", sheet_name)
+	}
+	frames, _ := strconv.Atoi(match[1])
+	vframes, _ := strconv.Atoi(match[2])
+	bounds := sheet.Bounds()
+	if frames <= 0 || vframes <= 0 || bounds.Dy()%vframes != 0 {
+		return nil, fmt.Errorf("can't detect the grid of %s: %d rows don't divide its %dpx height", sheet_name, vframes, bounds.Dy())
+	}
+	cell_height := bounds.Dy() / vframes
+
+	// Several -hf values give the same row count, keep the ones that divide the width evenly
+	// and prefer square cells, then the widest row.
+	hframes := 0
+	for candidate := frames; candidate >= 1; candidate-- {
+		if (frames+candidate-1)/candidate != vframes || bounds.Dx()%candidate != 0 {
+			continue
+		}
+		if bounds.Dx()/candidate == cell_height {
+			hframes = candidate
+			break
+		}
+		if hframes == 0 {
+			hframes = candidate
+		}
+	}
+	if hframes == 0 {
+		return nil, fmt.Errorf("can't detect the grid of %s: no column count fits %d frames in %d rows across %dpx", sheet_name, frames, vframes, bounds.Dx())
+	}
+	grid := cutGrid{cell_width: bounds.Dx() / hframes, cell_height: cell_height}
+	return grid.rects(bounds, frames), nil
+}
+
+// sheetBackground guesses the backdrop of a sheet cut without -bg: the colour of its four corner pixels
+// when they agree and are opaque. It returns nil for transparent sheets or when the corners differ.
+func sheetBackground(sheet image.Image) *color.NRGBA {
+	bounds := sheet.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+	corners := []image.Point{bounds.Min, {bounds.Max.X - 1, bounds.Min.Y}, {bounds.Min.X, bounds.Max.Y - 1}, bounds.Max.Sub(image.Pt(1, 1))}
+	background := color.NRGBAModel.Convert(sheet.At(bounds.Min.X, bounds.Min.Y)).(color.NRGBA)
+	if background.A != 255 {
+		return nil
+	}
+	for _, corner := range corners[1:] {
+		if color.NRGBAModel.Convert(sheet.At(corner.X, corner.Y)).(color.NRGBA) != background {
+			return nil
+		}
+	}
+	return &background
+}
+
+// isEmptyCell reports whether every pixel of cell is transparent or the background colour key, i.e. unused
+// sheet background. Solid tiles of any other colour (floors, water, UI blocks) are real frames and are kept.
+func isEmptyCell(cell *image.NRGBA, key *color.NRGBA) bool {
+	bounds := cell.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := cell.Pix[cell.PixOffset(bounds.Min.X, y):cell.PixOffset(bounds.Max.X, y)]
+		for x := 0; x < len(row); x += 4 {
+			if !isBackground(color.NRGBA{row[x], row[x+1], row[x+2], row[x+3]}, key) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,237 @@
+package gontage
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsEmptyCell(t *testing.T) {
+	magenta := color.NRGBA{255, 0, 255, 255}
+	solid := func(c color.NRGBA) *image.NRGBA {
+		// Offset bounds, like a cell cut from the second column.
+		cell := image.NewNRGBA(image.Rect(16, 0, 32, 16))
+		fillTestRect(cell, cell.Bounds(), c)
+		return cell
+	}
+	keyed := solid(magenta)
+	keyed.SetNRGBA(20, 4, color.NRGBA{})
+	for _, tc := range []struct {
+		name string
+		cell *image.NRGBA
+		key  *color.NRGBA
+		want bool
+	}{
+		{"transparent", solid(color.NRGBA{}), nil, true},
+		{"transparent with colour left in", solid(color.NRGBA{40, 80, 120, 0}), nil, true},
+		{"solid water tile", solid(color.NRGBA{30, 90, 200, 255}), nil, false},
+		{"solid water tile with a key", solid(color.NRGBA{30, 90, 200, 255}), &magenta, false},
+		{"colour key and transparency", keyed, &magenta, true},
+		{"colour key without -bg", keyed, nil, false},
+	} {
+		if got := isEmptyCell(tc.cell, tc.key); got != tc.want {
+			t.Errorf("%s: isEmptyCell = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAutoCutRects(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		size  image.Point
+		cells int
+		cell  image.Point
+		// the last rect, which shows where the final (possibly partial) row stops
+		last image.Rectangle
+		err  bool
+	}{
+		// 7 columns gives square cells and a partial last row of 4, whose empty cells are skipped.
+		{"walk_f18_v3.png", image.Pt(112, 48), 18, image.Pt(16, 16), image.Rect(48, 32, 64, 48), false},
+		// 6 and 8 columns both fit 18 frames in 3 rows, the square 16x16 cells win.
+		{"walk_f18_v3.png", image.Pt(96, 48), 18, image.Pt(16, 16), image.Rect(80, 32, 96, 48), false},
+		// Without square cells the widest row (8 columns) wins.
+		{"walk_f18_v3.png", image.Pt(96, 60), 18, image.Pt(12, 20), image.Rect(12, 40, 24, 60), false},
+		// A -scales variant keeps its @2x suffix after the frame counts.
+		{"walk_f18_v3@2x.png", image.Pt(224, 96), 18, image.Pt(32, 32), image.Rect(96, 64, 128, 96), false},
+		{"walk_f4_v1.png", image.Pt(64, 16), 4, image.Pt(16, 16), image.Rect(48, 0, 64, 16), false},
+		{"walk.png", image.Pt(64, 16), 0, image.Point{}, image.Rectangle{}, true},
+		// 3 rows don't divide 50px.
+		{"walk_f18_v3.png", image.Pt(96, 50), 0, image.Point{}, image.Rectangle{}, true},
+		// No column count of 6 to 8 divides 100px.
+		{"walk_f18_v3.png", image.Pt(100, 48), 0, image.Point{}, image.Rectangle{}, true},
+	} {
+		sheet := image.NewNRGBA(image.Rectangle{Max: tc.size})
+		rects, err := autoCutRects(GontageArgs{Sprite_source_folder: t.TempDir()}, sheet, tc.name)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s %v: expected an error, got %d rects", tc.name, tc.size, len(rects))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %v: %v", tc.name, tc.size, err)
+			continue
+		}
+		if len(rects) != tc.cells {
+			t.Errorf("%s %v: %d rects, want %d", tc.name, tc.size, len(rects), tc.cells)
+			continue
+		}
+		if rects[0] != (image.Rectangle{Max: tc.cell}) {
+			t.Errorf("%s %v: first rect %v, want cells of %v", tc.name, tc.size, rects[0], tc.cell)
+		}
+		if last := rects[len(rects)-1]; last != tc.last {
+			t.Errorf("%s %v: last rect %v, want %v", tc.name, tc.size, last, tc.last)
+		}
+	}
+}
+
+func TestAutoCutRectsPrefersMetadata(t *testing.T) {
+	dir := t.TempDir()
+	// The name says 18 frames, the -meta json lists two frames of different sizes and wins.
+	metadata := sheetMetadata{Image: "walk_f18_v3.png", Frames: []frameMetadata{
+		{Name: "a", X: 0, Y: 0, W: 10, H: 12},
+		{Name: "b", X: 10, Y: 4, W: 6, H: 8},
+	}}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "walk_f18_v3.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Rects follow the sheet bounds, like a sub image's would.
+	sheet := image.NewNRGBA(image.Rect(5, 5, 101, 53))
+	rects, err := autoCutRects(GontageArgs{Sprite_source_folder: dir}, sheet, "walk_f18_v3.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []image.Rectangle{image.Rect(5, 5, 15, 17), image.Rect(15, 9, 21, 17)}
+	if len(rects) != len(want) || rects[0] != want[0] || rects[1] != want[1] {
+		t.Errorf("rects %v, want %v", rects, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "walk_f18_v3.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := autoCutRects(GontageArgs{Sprite_source_folder: dir}, sheet, "walk_f18_v3.png"); err == nil {
+		t.Errorf("expected an error for broken metadata")
+	}
+}
+
+func TestCutRects(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		gargs GontageArgs
+		size  image.Point
+		want  []image.Rectangle
+	}{
+		{"cell size, 2px left over", GontageArgs{Cut_spritesheet: "16x16"}, image.Pt(50, 34), []image.Rectangle{
+			image.Rect(0, 0, 16, 16), image.Rect(16, 0, 32, 16), image.Rect(32, 0, 48, 16),
+			image.Rect(0, 16, 16, 32), image.Rect(16, 16, 32, 32), image.Rect(32, 16, 48, 32),
+		}},
+		{"margin and spacing", GontageArgs{Cut_spritesheet: "16x16", Cut_margin: 2, Cut_spacing: 1}, image.Pt(37, 37), []image.Rectangle{
+			image.Rect(2, 2, 18, 18), image.Rect(19, 2, 35, 18),
+			image.Rect(2, 19, 18, 35), image.Rect(19, 19, 35, 35),
+		}},
+		{"offset", GontageArgs{Cut_spritesheet: "16X16", Cut_offset: "4, 2"}, image.Pt(52, 34), []image.Rectangle{
+			image.Rect(4, 2, 20, 18), image.Rect(20, 2, 36, 18), image.Rect(36, 2, 52, 18),
+			image.Rect(4, 18, 20, 34), image.Rect(20, 18, 36, 34), image.Rect(36, 18, 52, 34),
+		}},
+		{"offset and margin add up", GontageArgs{Cut_spritesheet: "16x16", Cut_offset: "4,2", Cut_margin: 1}, image.Pt(40, 20), []image.Rectangle{
+			image.Rect(5, 3, 21, 19), image.Rect(21, 3, 37, 19),
+		}},
+		// 44px shared by 3 columns makes 14px cells, the 2px remainder stays unused on the right.
+		{"grid with margin and spacing", GontageArgs{Cut_grid: "3x2", Cut_margin: 1, Cut_spacing: 2}, image.Pt(50, 34), []image.Rectangle{
+			image.Rect(1, 1, 15, 16), image.Rect(17, 1, 31, 16), image.Rect(33, 1, 47, 16),
+			image.Rect(1, 18, 15, 33), image.Rect(17, 18, 31, 33), image.Rect(33, 18, 47, 33),
+		}},
+	} {
+		sheet := image.NewNRGBA(image.Rectangle{Max: tc.size})
+		rects, err := cutRects(tc.gargs, sheet, "sheet.png")
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(rects) != len(tc.want) {
+			t.Errorf("%s: rects %v, want %v", tc.name, rects, tc.want)
+			continue
+		}
+		for i := range rects {
+			if rects[i] != tc.want[i] {
+				t.Errorf("%s: rect %d = %v, want %v", tc.name, i, rects[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestCutRectsErrors(t *testing.T) {
+	for name, gargs := range map[string]GontageArgs{
+		"negative offset":      {Cut_spritesheet: "16x16", Cut_offset: "-4,2"},
+		"one offset value":     {Cut_spritesheet: "16x16", Cut_offset: "4"},
+		"offset not a number":  {Cut_spritesheet: "16x16", Cut_offset: "a,b"},
+		"negative margin":      {Cut_spritesheet: "16x16", Cut_margin: -1},
+		"negative spacing":     {Cut_spritesheet: "16x16", Cut_spacing: -2},
+		"one cell size":        {Cut_spritesheet: "16"},
+		"zero cell width":      {Cut_spritesheet: "0x16"},
+		"bad grid":             {Cut_grid: "3"},
+		"grid too fine":        {Cut_grid: "40x2"},
+		"margin eats the grid": {Cut_grid: "2x2", Cut_margin: 16},
+	} {
+		sheet := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+		if rects, err := cutRects(gargs, sheet, "sheet.png"); err == nil {
+			t.Errorf("%s: expected an error, got %v", name, rects)
+		}
+	}
+}
+
+func TestSheetBackground(t *testing.T) {
+	magenta := color.NRGBA{255, 0, 255, 255}
+	backdrop := func() *image.NRGBA {
+		sheet := image.NewNRGBA(image.Rect(4, 2, 36, 18))
+		fillTestRect(sheet, sheet.Bounds(), magenta)
+		return sheet
+	}
+	if key := sheetBackground(backdrop()); key == nil || *key != magenta {
+		t.Errorf("backdrop key = %v, want %v", key, magenta)
+	}
+	one_corner := backdrop()
+	one_corner.SetNRGBA(35, 17, color.NRGBA{0, 0, 255, 255})
+	if key := sheetBackground(one_corner); key != nil {
+		t.Errorf("corners differ, key = %v, want none", key)
+	}
+	if key := sheetBackground(image.NewNRGBA(image.Rect(0, 0, 8, 8))); key != nil {
+		t.Errorf("transparent sheet, key = %v, want none", key)
+	}
+}
+
+func TestCutSkipsBackdropCells(t *testing.T) {
+	inTempDir(t, func() {
+		// Four 8px cells on a magenta backdrop: a sprite, backdrop only, a solid blue tile and backdrop again.
+		magenta := color.NRGBA{255, 0, 255, 255}
+		blue := color.NRGBA{0, 0, 255, 255}
+		sheet := solidTestImage(32, 8, magenta)
+		fillTestRect(sheet, image.Rect(16, 0, 24, 8), blue)
+		sheet.SetNRGBA(3, 3, color.NRGBA{255, 0, 0, 255})
+		os.Mkdir("sheets", 0755)
+		writeTestPng(t, filepath.Join("sheets", "tiles.png"), sheet)
+		Gontage(GontageArgs{Sprite_source_folder: "sheets", Cut_spritesheet: "8x8", Skip_empty: true, Jpeg_quality: 100})
+
+		entries, err := os.ReadDir(filepath.Join("sheets", "tiles"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("cut %d cells, want the sprite and the blue tile", len(entries))
+		}
+		// The backdrop is only used to spot empty cells, without -bg it stays in the kept sprites.
+		if got := readTestPng(t, filepath.Join("sheets", "tiles", "0.png")).NRGBAAt(0, 0); got != magenta {
+			t.Errorf("sprite backdrop = %v, want %v", got, magenta)
+		}
+		if got := readTestPng(t, filepath.Join("sheets", "tiles", "1.png")).NRGBAAt(0, 0); got != blue {
+			t.Errorf("second cell = %v, want the blue tile", got)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// cutDetect is the -x value that finds sprites on irregular sheets by their connected pixels.
+const cutDetect = "detect"
+
+// isBackground reports whether c is sheet background: fully transparent, or the -bg colour key when one is set.
+func isBackground(c color.NRGBA, key *color.NRGBA) bool {
+	if c.A == 0 {
+		return true
+	}
+	return key != nil && c == *key
+}
+
+// detectSpriteRects finds the bounding boxes of 8-connected non-background regions, merges boxes that
+// are within merge_distance px of each other and returns them in reading order.
+func detectSpriteRects(sheet image.Image, key *color.NRGBA, merge_distance int) []image.Rectangle {
+	src := toNRGBA(sheet)
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	visited := make([]bool, width*height)
+	var rects []image.Rectangle
+	var stack []int
+	for start := range visited {
+		if visited[start] || isBackground(src.NRGBAAt(bounds.Min.X+start%width, bounds.Min.Y+start/width), key) {
+			continue
+		}
+		visited[start] = true
+		region := image.Rect(start%width, start/width, start%width+1, start/width+1)
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%width, i/width
+			region = region.Union(image.Rect(x, y, x+1, y+1))
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= width || ny >= height {
+						continue
+					}
+					n := ny*width + nx
+					if visited[n] || isBackground(src.NRGBAAt(bounds.Min.X+nx, bounds.Min.Y+ny), key) {
+						continue
+					}
+					visited[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+		rects = append(rects, region.Add(bounds.Min))
+	}
+	return readingOrder(mergeRects(rects, merge_distance))
+}
+
+// mergeRects unions rects that overlap or are at most distance px apart until none are left to merge.
+func mergeRects(rects []image.Rectangle, distance int) []image.Rectangle {
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(rects); i++ {
+			for j := i + 1; j < len(rects); j++ {
+				gap_x := max(rects[j].Min.X-rects[i].Max.X, rects[i].Min.X-rects[j].Max.X)
+				gap_y := max(rects[j].Min.Y-rects[i].Max.Y, rects[i].Min.Y-rects[j].Max.Y)
+				if gap_x <= distance && gap_y <= distance {
+					rects[i] = rects[i].Union(rects[j])
+					rects = append(rects[:j], rects[j+1:]...)
+					merged = true
+					j = i
+				}
+			}
+		}
+	}
+	return rects
+}
+
+// readingOrder sorts rects into rows (rects that overlap vertically share a row), top to bottom, then left to right.
+func readingOrder(rects []image.Rectangle) []image.Rectangle {
+	sort.Slice(rects, func(i, j int) bool { return rects[i].Min.Y < rects[j].Min.Y })
+	var ordered []image.Rectangle
+	for len(rects) > 0 {
+		row_bottom := rects[0].Max.Y
+		row_end := 1
+		for row_end < len(rects) && rects[row_end].Min.Y < row_bottom {
+			row_bottom = max(row_bottom, rects[row_end].Max.Y)
+			row_end++
+		}
+		row := rects[:row_end]
+		sort.SliceStable(row, func(i, j int) bool { return row[i].Min.X < row[j].Min.X })
+		ordered = append(ordered, row...)
+		rects = rects[row_end:]
+	}
+	return ordered
+}
+
+// clearBackground makes pixels matching the -bg colour key transparent.
+func clearBackground(img *image.NRGBA, key *color.NRGBA) {
+	if key == nil {
+		return
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if img.NRGBAAt(x, y) == *key {
+				img.SetNRGBA(x, y, color.NRGBA{})
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// detectTestSheet is a 30x16 sheet with two sprites on background. The first has a pixel touching it
+// diagonally and a part 2px to its right, the second a part 5px to its right and 2px below it.
+func detectTestSheet(background color.NRGBA) *image.NRGBA {
+	sheet := solidTestImage(30, 16, background)
+	red := color.NRGBA{200, 0, 0, 255}
+	fillTestRect(sheet, image.Rect(2, 2, 8, 10), red)
+	fillTestRect(sheet, image.Rect(8, 10, 9, 11), red)
+	fillTestRect(sheet, image.Rect(10, 4, 11, 6), red)
+	fillTestRect(sheet, image.Rect(16, 2, 22, 10), red)
+	fillTestRect(sheet, image.Rect(27, 12, 28, 13), red)
+	return sheet
+}
+
+func TestDetectSpriteRects(t *testing.T) {
+	magenta := color.NRGBA{255, 0, 255, 255}
+	for _, tc := range []struct {
+		name           string
+		background     color.NRGBA
+		key            *color.NRGBA
+		merge_distance int
+		want           []image.Rectangle
+	}{
+		{"no merging", color.NRGBA{}, nil, 0, []image.Rectangle{
+			image.Rect(2, 2, 9, 11), image.Rect(10, 4, 11, 6), image.Rect(16, 2, 22, 10), image.Rect(27, 12, 28, 13),
+		}},
+		{"merge 2px", color.NRGBA{}, nil, 2, []image.Rectangle{
+			image.Rect(2, 2, 11, 11), image.Rect(16, 2, 22, 10), image.Rect(27, 12, 28, 13),
+		}},
+		{"-bg colour key", magenta, &magenta, 2, []image.Rectangle{
+			image.Rect(2, 2, 11, 11), image.Rect(16, 2, 22, 10), image.Rect(27, 12, 28, 13),
+		}},
+		// Without -bg an opaque background is one big sprite.
+		{"opaque background without -bg", magenta, nil, 2, []image.Rectangle{image.Rect(0, 0, 30, 16)}},
+	} {
+		rects := detectSpriteRects(detectTestSheet(tc.background), tc.key, tc.merge_distance)
+		if len(rects) != len(tc.want) {
+			t.Errorf("%s: rects %v, want %v", tc.name, rects, tc.want)
+			continue
+		}
+		for i := range rects {
+			if rects[i] != tc.want[i] {
+				t.Errorf("%s: rect %d = %v, want %v", tc.name, i, rects[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestDetectSpriteRectsSubImage(t *testing.T) {
+	// Rects are in the sheet's coordinates, wherever its bounds start.
+	sheet := detectTestSheet(color.NRGBA{}).SubImage(image.Rect(12, 0, 30, 16))
+	rects := detectSpriteRects(sheet, nil, 2)
+	want := []image.Rectangle{image.Rect(16, 2, 22, 10), image.Rect(27, 12, 28, 13)}
+	if len(rects) != 2 || rects[0] != want[0] || rects[1] != want[1] {
+		t.Errorf("rects %v, want %v", rects, want)
+	}
+}
+
+func TestClearBackground(t *testing.T) {
+	magenta := color.NRGBA{255, 0, 255, 255}
+	sheet := detectTestSheet(magenta)
+	clearBackground(sheet, &magenta)
+	if got := sheet.NRGBAAt(0, 0); got != (color.NRGBA{}) {
+		t.Errorf("background pixel = %v, want transparent", got)
+	}
+	if got := sheet.NRGBAAt(2, 2); got != (color.NRGBA{200, 0, 0, 255}) {
+		t.Errorf("sprite pixel = %v, want it unchanged", got)
+	}
+}
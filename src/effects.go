@@ -0,0 +1,421 @@
+package gontage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Outline positions accepted by GontageArgs.Outline_position (-op).
+const (
+	outlineOutside = "outside"
+	outlineInside  = "inside"
+	outlineCenter  = "center"
+)
+
+// outlineSolidAlpha is the alpha from which a pixel counts as part of the sprite's shape.
+const outlineSolidAlpha = 128
+
+func validateEffectOptions(gargs GontageArgs) error {
+	if gargs.Outline_width < 0 {
+		return fmt.Errorf("outline width can't be negative")
+	}
+	switch strings.ToLower(gargs.Outline_position) {
+	case "", outlineOutside, outlineInside, outlineCenter:
+	default:
+		return fmt.Errorf("unknown outline position %q (expected outside, inside or center)", gargs.Outline_position)
+	}
+	if gargs.Outline_color != "" {
+		if _, err := parseHexColor(gargs.Outline_color); err != nil {
+			return fmt.Errorf("outline colour: %v", err)
+		}
+	}
+	if _, err := parseShadowOffset(gargs.Shadow_offset); err != nil {
+		return err
+	}
+	if gargs.Shadow_blur < 0 {
+		return fmt.Errorf("shadow blur can't be negative")
+	}
+	if gargs.Glow_radius < 0 {
+		return fmt.Errorf("glow radius can't be negative")
+	}
+	if gargs.Shadow_opacity < 0 || gargs.Shadow_opacity > 100 {
+		return fmt.Errorf("shadow opacity %d out of range (expected 0-100)", gargs.Shadow_opacity)
+	}
+	if gargs.Glow_opacity < 0 || gargs.Glow_opacity > 100 {
+		return fmt.Errorf("glow opacity %d out of range (expected 0-100)", gargs.Glow_opacity)
+	}
+	if gargs.Shadow_color != "" {
+		if _, err := parseHexColor(gargs.Shadow_color); err != nil {
+			return fmt.Errorf("shadow colour: %v", err)
+		}
+	}
+	if gargs.Glow_color != "" {
+		if _, err := parseHexColor(gargs.Glow_color); err != nil {
+			return fmt.Errorf("glow colour: %v", err)
+		}
+	}
+	return nil
+}
+
+func hasEffects(gargs GontageArgs) bool {
+	return gargs.Outline_width > 0 || hasShadow(gargs) || gargs.Glow_radius > 0
+}
+
+func hasShadow(gargs GontageArgs) bool {
+	return gargs.Shadow_offset != ""
+}
+
+// parseShadowOffset parses -shadow values like "4,4" or "-2,3", in px right and down from the sprite.
+func parseShadowOffset(offset string) (image.Point, error) {
+	if offset == "" {
+		return image.Point{}, nil
+	}
+	parts := strings.Split(offset, ",")
+	if len(parts) != 2 {
+		return image.Point{}, fmt.Errorf("invalid shadow offset %q (expected X,Y e.g. 4,4)", offset)
+	}
+	x, err_x := strconv.Atoi(strings.TrimSpace(parts[0]))
+	y, err_y := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err_x != nil || err_y != nil {
+		return image.Point{}, fmt.Errorf("invalid shadow offset %q (expected X,Y e.g. 4,4)", offset)
+	}
+	return image.Pt(x, y), nil
+}
+
+// applyEffects adds the -outline stroke, then the -glow and -shadow behind the sprite.
+// It runs after resizing and before fading.
+func applyEffects(img image.Image, gargs GontageArgs) *image.NRGBA {
+	sprite := toNRGBA(img)
+	if gargs.Outline_width > 0 {
+		sprite = applyOutline(sprite, gargs)
+	}
+	if hasShadow(gargs) || gargs.Glow_radius > 0 {
+		sprite = applyShadowAndGlow(sprite, gargs)
+	}
+	return sprite
+}
+
+func effectSprites(gargs GontageArgs, all_decoded_images []image.Image) []image.Image {
+	effect_images := make([]image.Image, len(all_decoded_images))
+	var effect_wg sync.WaitGroup
+	for i, decoded_image := range all_decoded_images {
+		effect_wg.Add(1)
+		go func(i int, decoded_image image.Image) {
+			defer effect_wg.Done()
+			effect_images[i] = applyEffects(decoded_image, gargs)
+		}(i, decoded_image)
+	}
+	effect_wg.Wait()
+	return effect_images
+}
+
+// applyOutline strokes the edge of the sprite's shape with -oc. Outside and centered strokes grow the
+// canvas on every side so the stroke isn't clipped, an inside stroke keeps the sprite's size and alpha.
+func applyOutline(img image.Image, gargs GontageArgs) *image.NRGBA {
+	stroke_color := color.NRGBA{255, 255, 255, 255}
+	if gargs.Outline_color != "" {
+		stroke_color, _ = parseHexColor(gargs.Outline_color)
+	}
+	width := float64(gargs.Outline_width)
+	inside_width, outside_width := 0.0, width
+	switch strings.ToLower(gargs.Outline_position) {
+	case outlineInside:
+		inside_width, outside_width = width, 0
+	case outlineCenter:
+		// Whole pixels either side of the edge, an odd width puts the extra pixel outside.
+		inside_width = math.Floor(width / 2)
+		outside_width = width - inside_width
+	}
+
+	src := toNRGBA(img)
+	src_bounds := src.Bounds()
+	padding := int(math.Ceil(outside_width))
+	canvas := image.NewNRGBA(image.Rect(0, 0, src_bounds.Dx()+2*padding, src_bounds.Dy()+2*padding))
+	drawNRGBA(canvas, src_bounds.Sub(src_bounds.Min).Add(image.Pt(padding, padding)), src, src_bounds.Min)
+
+	canvas_width, canvas_height := canvas.Bounds().Dx(), canvas.Bounds().Dy()
+	solid := make([]bool, canvas_width*canvas_height)
+	for i := range solid {
+		solid[i] = canvas.Pix[i*4+3] >= outlineSolidAlpha
+	}
+	// Distance from every pixel to the nearest solid pixel, and from solid pixels to the nearest gap.
+	outside_distance := distanceField(solid, canvas_width, canvas_height, true)
+	inside_distance := distanceField(solid, canvas_width, canvas_height, false)
+
+	parallelRows(canvas_height, func(y_start int, y_end int) {
+		for y := y_start; y < y_end; y++ {
+			for x := 0; x < canvas_width; x++ {
+				i := y*canvas_width + x
+				pixel := canvas.Pix[i*4 : i*4+4]
+				if solid[i] {
+					coverage := strokeCoverage(inside_distance[i], inside_width, gargs.Outline_antialias)
+					// The inside stroke is painted over the sprite but keeps the sprite's alpha.
+					blendOver(pixel, stroke_color, coverage)
+					continue
+				}
+				coverage := strokeCoverage(outside_distance[i], outside_width, gargs.Outline_antialias)
+				// The outside stroke goes behind the (partly transparent) edge pixels of the sprite.
+				blendUnder(pixel, stroke_color, coverage)
+			}
+		}
+	})
+	return canvas
+}
+
+// strokeCoverage is how much of a pixel a stroke of width px covers, given the distance between the
+// pixel's centre and the nearest pixel centre across the edge (1 for a neighbouring pixel).
+func strokeCoverage(distance float64, width float64, antialias bool) float64 {
+	if width <= 0 {
+		return 0
+	}
+	if !antialias {
+		if distance < width+0.5 {
+			return 1
+		}
+		return 0
+	}
+	return math.Max(0, math.Min(1, width+1-distance))
+}
+
+// blendOver paints c with coverage over the straight alpha pixel's colour, leaving its alpha as it is.
+func blendOver(pixel []uint8, c color.NRGBA, coverage float64) {
+	amount := coverage * float64(c.A) / 255
+	if amount <= 0 {
+		return
+	}
+	for ch, v := range [3]uint8{c.R, c.G, c.B} {
+		pixel[ch] = uint8(math.Round(float64(pixel[ch])*(1-amount) + float64(v)*amount))
+	}
+}
+
+// blendUnder composites the straight alpha pixel over c with coverage.
+func blendUnder(pixel []uint8, c color.NRGBA, coverage float64) {
+	under := coverage * float64(c.A) / 255
+	if under <= 0 {
+		return
+	}
+	over := float64(pixel[3]) / 255
+	alpha := over + under*(1-over)
+	for ch, v := range [3]uint8{c.R, c.G, c.B} {
+		pixel[ch] = uint8(math.Round((float64(pixel[ch])*over + float64(v)*under*(1-over)) / alpha))
+	}
+	pixel[3] = uint8(math.Round(alpha * 255))
+}
+
+// distanceField gives every pixel's Euclidean distance to the nearest pixel where solid == target,
+// using the two pass squared distance transform of Felzenszwalb and Huttenlocher.
+// Pixels beyond the image count as not solid.
+func distanceField(solid []bool, width int, height int, target bool) []float64 {
+	infinity := float64((width + height + 2) * (width + height + 2))
+	// Pad by one pixel so the area beyond the edges takes part when looking for gaps.
+	padded_width, padded_height := width+2, height+2
+	squared := make([]float64, padded_width*padded_height)
+	for y := 0; y < padded_height; y++ {
+		for x := 0; x < padded_width; x++ {
+			is_solid := x > 0 && y > 0 && x <= width && y <= height && solid[(y-1)*width+x-1]
+			if is_solid == target {
+				squared[y*padded_width+x] = 0
+			} else {
+				squared[y*padded_width+x] = infinity
+			}
+		}
+	}
+	column := make([]float64, padded_height)
+	for x := 0; x < padded_width; x++ {
+		for y := range column {
+			column[y] = squared[y*padded_width+x]
+		}
+		transformed := distanceTransform1D(column)
+		for y := range column {
+			squared[y*padded_width+x] = transformed[y]
+		}
+	}
+	for y := 0; y < padded_height; y++ {
+		row := squared[y*padded_width : (y+1)*padded_width]
+		copy(row, distanceTransform1D(row))
+	}
+	distances := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			distances[y*width+x] = math.Sqrt(squared[(y+1)*padded_width+x+1])
+		}
+	}
+	return distances
+}
+
+// distanceTransform1D is the lower envelope of parabolas rooted at each sample of f.
+func distanceTransform1D(f []float64) []float64 {
+	n := len(f)
+	d := make([]float64, n)
+	v := make([]int, n)
+	z := make([]float64, n+1)
+	k := 0
+	z[0], z[1] = math.Inf(-1), math.Inf(1)
+	for q := 1; q < n; q++ {
+		s := ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		for s <= z[k] {
+			k--
+			s = ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		}
+		k++
+		v[k] = q
+		z[k], z[k+1] = s, math.Inf(1)
+	}
+	k = 0
+	for q := 0; q < n; q++ {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		d[q] = float64((q-v[k])*(q-v[k])) + f[v[k]]
+	}
+	return d
+}
+
+// applyShadowAndGlow puts an outer glow and a drop shadow behind the sprite, both cast by the sprite's
+// alpha (including any outline). The canvas grows evenly on every side so the sprite stays centred and
+// every sprite in a sheet keeps the same size.
+func applyShadowAndGlow(sprite *image.NRGBA, gargs GontageArgs) *image.NRGBA {
+	shadow_offset, _ := parseShadowOffset(gargs.Shadow_offset)
+	padding := 0
+	if hasShadow(gargs) {
+		padding = gargs.Shadow_blur + max(abs(shadow_offset.X), abs(shadow_offset.Y))
+	}
+	padding = max(padding, gargs.Glow_radius)
+
+	bounds := sprite.Bounds()
+	canvas := image.NewNRGBA(image.Rect(0, 0, bounds.Dx()+2*padding, bounds.Dy()+2*padding))
+	drawNRGBA(canvas, bounds.Sub(bounds.Min).Add(image.Pt(padding, padding)), sprite, bounds.Min)
+	width, height := canvas.Bounds().Dx(), canvas.Bounds().Dy()
+
+	var glow []float64
+	if gargs.Glow_radius > 0 {
+		glow = glowCoverage(canvas, float64(gargs.Glow_radius))
+	}
+	var shadow []float64
+	if hasShadow(gargs) {
+		shadow = make([]float64, width*height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				src_x, src_y := x-shadow_offset.X, y-shadow_offset.Y
+				if src_x >= 0 && src_y >= 0 && src_x < width && src_y < height {
+					shadow[y*width+x] = float64(canvas.Pix[(src_y*width+src_x)*4+3]) / 255
+				}
+			}
+		}
+		shadow = gaussianBlur(shadow, width, height, gargs.Shadow_blur)
+	}
+
+	glow_color := effectColor(gargs.Glow_color, color.NRGBA{255, 255, 255, 255}, gargs.Glow_opacity)
+	shadow_color := effectColor(gargs.Shadow_color, color.NRGBA{0, 0, 0, 255}, gargs.Shadow_opacity)
+	parallelRows(height, func(y_start int, y_end int) {
+		for i := y_start * width; i < y_end*width; i++ {
+			pixel := canvas.Pix[i*4 : i*4+4]
+			// The glow goes behind the sprite and the shadow behind both.
+			if glow != nil {
+				blendUnder(pixel, glow_color, glow[i])
+			}
+			if shadow != nil {
+				blendUnder(pixel, shadow_color, shadow[i])
+			}
+		}
+	})
+	return canvas
+}
+
+// effectColor is the -sc/-gc colour, or fallback when unset, with its alpha scaled by opacity percent.
+func effectColor(hex string, fallback color.NRGBA, opacity int) color.NRGBA {
+	c := fallback
+	if hex != "" {
+		c, _ = parseHexColor(hex)
+	}
+	c.A = uint8(math.Round(float64(c.A) * float64(opacity) / 100))
+	return c
+}
+
+// glowCoverage fades from fully covered next to the sprite's shape to nothing radius px away from it.
+func glowCoverage(canvas *image.NRGBA, radius float64) []float64 {
+	width, height := canvas.Bounds().Dx(), canvas.Bounds().Dy()
+	solid := make([]bool, width*height)
+	for i := range solid {
+		solid[i] = canvas.Pix[i*4+3] >= outlineSolidAlpha
+	}
+	distance := distanceField(solid, width, height, true)
+	coverage := make([]float64, width*height)
+	for i, d := range distance {
+		// 0 next to the shape, 1 at the edge of the glow.
+		t := math.Max(0, math.Min(1, (d-1)/radius))
+		coverage[i] = (1 - t) * (1 - t)
+	}
+	return coverage
+}
+
+// gaussianBlur approximates a gaussian blur reaching radius px with three box blurs.
+func gaussianBlur(values []float64, width int, height int, radius int) []float64 {
+	if radius <= 0 {
+		return values
+	}
+	box_radius, passes := radius/3, 3
+	if box_radius == 0 {
+		box_radius, passes = radius, 1
+	}
+	for range passes {
+		values = boxBlur(values, width, height, box_radius)
+	}
+	return values
+}
+
+// boxBlur averages every value with its neighbours up to radius away, first along rows then columns.
+// Values beyond the edges count as 0.
+func boxBlur(values []float64, width int, height int, radius int) []float64 {
+	window := float64(2*radius + 1)
+	horizontal := make([]float64, len(values))
+	parallelRows(height, func(y_start int, y_end int) {
+		for y := y_start; y < y_end; y++ {
+			row := values[y*width : (y+1)*width]
+			sum := 0.0
+			for x := 0; x < min(radius, width); x++ {
+				sum += row[x]
+			}
+			for x := 0; x < width; x++ {
+				if x+radius < width {
+					sum += row[x+radius]
+				}
+				if x-radius-1 >= 0 {
+					sum -= row[x-radius-1]
+				}
+				horizontal[y*width+x] = sum / window
+			}
+		}
+	})
+	blurred := make([]float64, len(values))
+	parallelRows(width, func(x_start int, x_end int) {
+		for x := x_start; x < x_end; x++ {
+			sum := 0.0
+			for y := 0; y < min(radius, height); y++ {
+				sum += horizontal[y*width+x]
+			}
+			for y := 0; y < height; y++ {
+				if y+radius < height {
+					sum += horizontal[(y+radius)*width+x]
+				}
+				if y-radius-1 >= 0 {
+					sum -= horizontal[(y-radius-1)*width+x]
+				}
+				blurred[y*width+x] = sum / window
+			}
+		}
+	})
+	return blurred
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
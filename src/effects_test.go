@@ -0,0 +1,113 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// outlineTestSprite is a 4x4 opaque blue square in the middle of an 8x8 transparent sprite.
+func outlineTestSprite() *image.NRGBA {
+	sprite := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	fillTestRect(sprite, image.Rect(2, 2, 6, 6), color.NRGBA{0, 0, 255, 255})
+	return sprite
+}
+
+func TestApplyOutline(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	for _, tc := range []struct {
+		position string
+		width    int
+		size     int
+		// pixels checked on the outlined sprite
+		want map[image.Point]color.NRGBA
+	}{
+		{outlineOutside, 2, 12, map[image.Point]color.NRGBA{
+			{3, 3}: red, {3, 5}: red, {2, 5}: red, {4, 4}: blue, {1, 5}: {}, {1, 1}: {}, {3, 9}: red, {2, 9}: {},
+		}},
+		{outlineInside, 1, 8, map[image.Point]color.NRGBA{
+			{1, 1}: {}, {2, 2}: red, {3, 3}: blue, {2, 4}: red, {4, 5}: red,
+		}},
+		{outlineCenter, 2, 10, map[image.Point]color.NRGBA{
+			{2, 2}: red, {3, 3}: red, {4, 4}: blue, {1, 1}: {},
+		}},
+	} {
+		gargs := GontageArgs{Outline_width: tc.width, Outline_color: "ff0000", Outline_position: tc.position}
+		outlined := applyOutline(outlineTestSprite(), gargs)
+		if size := outlined.Bounds().Dx(); size != tc.size {
+			t.Errorf("%s: size %d, want %d", tc.position, size, tc.size)
+			continue
+		}
+		for p, want := range tc.want {
+			if got := outlined.NRGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", tc.position, p, got, want)
+			}
+		}
+	}
+}
+
+func TestApplyOutlineAntialias(t *testing.T) {
+	gargs := GontageArgs{Outline_width: 1, Outline_color: "ff0000", Outline_antialias: true}
+	outlined := applyOutline(outlineTestSprite(), gargs)
+	// Straight neighbours are fully covered, the diagonal corner only partly.
+	if edge := outlined.NRGBAAt(2, 4); edge.A != 255 {
+		t.Errorf("edge alpha %d, want 255", edge.A)
+	}
+	if corner := outlined.NRGBAAt(2, 2); corner.A == 0 || corner.A == 255 {
+		t.Errorf("corner alpha %d, want partial coverage", corner.A)
+	}
+}
+
+func TestApplyShadow(t *testing.T) {
+	gargs := GontageArgs{Shadow_offset: "3,-2", Shadow_color: "000000", Shadow_opacity: 50}
+	shadowed := applyEffects(outlineTestSprite(), gargs)
+	// 3 px of padding on every side keeps the sprite centred.
+	if size := shadowed.Bounds().Size(); size != image.Pt(14, 14) {
+		t.Fatalf("size %v, want 14x14", size)
+	}
+	if got, want := shadowed.NRGBAAt(5, 5), (color.NRGBA{0, 0, 255, 255}); got != want {
+		t.Errorf("sprite pixel = %v, want %v", got, want)
+	}
+	// The square spans 5-8, its shadow 8-11 across and 3-6 down.
+	if got, want := shadowed.NRGBAAt(10, 3), (color.NRGBA{0, 0, 0, 128}); got != want {
+		t.Errorf("shadow pixel = %v, want %v", got, want)
+	}
+	if got := shadowed.NRGBAAt(10, 7); got.A != 0 {
+		t.Errorf("pixel below the shadow = %v, want transparent", got)
+	}
+}
+
+func TestGaussianBlurKeepsTotal(t *testing.T) {
+	values := make([]float64, 21*21)
+	values[10*21+10] = 1
+	blurred := gaussianBlur(values, 21, 21, 6)
+	total := 0.0
+	for _, v := range blurred {
+		total += v
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("blurred total %v, want 1", total)
+	}
+	if blurred[10*21+10] <= blurred[10*21+13] || blurred[10*21+17] > 1e-12 {
+		t.Errorf("blur should peak at the centre and stop 6 px away")
+	}
+}
+
+func TestGlowFadesOut(t *testing.T) {
+	gargs := GontageArgs{Glow_radius: 4, Glow_color: "ffff00", Glow_opacity: 100}
+	glowing := applyEffects(outlineTestSprite(), gargs)
+	// The square spans 6-9 on the 16x16 canvas.
+	previous := uint8(255)
+	for x := 5; x >= 1; x-- {
+		got := glowing.NRGBAAt(x, 7)
+		if got.A > previous || (got.A > 0 && (got.R != 255 || got.G != 255 || got.B != 0)) {
+			t.Errorf("pixel %d = %v, want a yellow glow fading out", x, got)
+		}
+		previous = got.A
+	}
+	if glowing.NRGBAAt(5, 7).A != 255 || glowing.NRGBAAt(1, 7).A != 0 {
+		t.Errorf("glow should be opaque next to the sprite and gone %d px away", gargs.Glow_radius)
+	}
+}
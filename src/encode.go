@@ -0,0 +1,324 @@
+package gontage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/dblezek/tga"
+)
+
+// Output formats accepted by GontageArgs.Output_format (-of).
+const (
+	formatPng  = "png"
+	formatJpeg = "jpeg"
+	formatTga  = "tga"
+	formatBmp  = "bmp"
+	formatGif  = "gif"
+	formatQoi  = "qoi"
+)
+
+// ValidateOutputOptions checks the output format and encoder settings before any work is done.
+func ValidateOutputOptions(gargs GontageArgs) error {
+	if _, err := parseOutputFormat(gargs.Output_format); err != nil {
+		return err
+	}
+	if _, err := parsePngCompression(gargs.Png_compression); err != nil {
+		return err
+	}
+	if gargs.Jpeg_quality < 1 || gargs.Jpeg_quality > 100 {
+		return fmt.Errorf("jpeg quality must be between 1 and 100, got %d", gargs.Jpeg_quality)
+	}
+	if err := validateQuantizeOptions(gargs); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseOutputFormat normalises a user supplied format, an empty string keeps the default behaviour.
+func parseOutputFormat(format string) (string, error) {
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "":
+		return "", nil
+	case "png":
+		return formatPng, nil
+	case "jpg", "jpeg":
+		return formatJpeg, nil
+	case "tga":
+		return formatTga, nil
+	case "bmp":
+		return formatBmp, nil
+	case "gif":
+		return formatGif, nil
+	case "qoi":
+		return formatQoi, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (expected png, jpeg, tga, bmp, gif or qoi)", format)
+}
+
+func parsePngCompression(level string) (png.CompressionLevel, error) {
+	switch strings.ToLower(level) {
+	case "", "speed":
+		return png.BestSpeed, nil
+	case "default":
+		return png.DefaultCompression, nil
+	case "best":
+		return png.BestCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	}
+	return png.BestSpeed, fmt.Errorf("unknown png compression %q (expected none, speed, default or best)", level)
+}
+
+// jpegExts are the extensions JPEG files go by, without the dot.
+var jpegExts = []string{"jpg", "jpeg", "jfif", "pjpeg", "pjp"}
+
+func isJpegExt(ext string) bool {
+	return slices.Contains(jpegExts, strings.TrimPrefix(strings.ToLower(ext), "."))
+}
+
+// outputFormatFor picks the format for an image whose source had source_ext.
+// Without -of, JPG sources stay JPG unless faded or outlined (both need transparency) and everything else becomes PNG.
+func outputFormatFor(gargs GontageArgs, source_ext string) string {
+	if format, _ := parseOutputFormat(gargs.Output_format); format != "" {
+		return format
+	}
+	if isJpegExt(source_ext) && !isFading(gargs) && !hasEffects(gargs) {
+		return formatJpeg
+	}
+	return formatPng
+}
+
+// sheetOutputFormat is the format for spritesheets and cut sprites, PNG unless -of says otherwise.
+func sheetOutputFormat(gargs GontageArgs) string {
+	if format, _ := parseOutputFormat(gargs.Output_format); format != "" {
+		return format
+	}
+	return formatPng
+}
+
+// outputExtension returns the file extension (with dot) for format.
+// JPEG output keeps the source's own JPEG extension so -ss and -i don't rename .jpeg files.
+func outputExtension(format string, source_ext string) string {
+	if format == formatJpeg {
+		if isJpegExt(source_ext) {
+			return "." + strings.TrimPrefix(source_ext, ".")
+		}
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// encodeImage writes img to w in format using the encoder settings from gargs.
+// With -colors set, PNG and GIF output is quantized and written as an indexed image.
+// With -optimize set, PNG output goes through the lossless size optimizer instead of the -pc level.
+func encodeImage(w io.Writer, img image.Image, format string, gargs GontageArgs) error {
+	if gargs.Palette_colors > 0 && (format == formatPng || format == formatGif) {
+		img = quantizeImage(img, gargs.Palette_colors, gargs.Quantizer, gargs.Dither)
+	}
+	switch format {
+	case formatJpeg:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: gargs.Jpeg_quality})
+	case formatTga:
+		return tga.Encode(w, img)
+	case formatBmp:
+		return encodeBmp(w, img)
+	case formatGif:
+		if paletted, ok := img.(*image.Paletted); ok {
+			return gif.Encode(w, paletted, nil)
+		}
+		return gif.Encode(w, toTransparentPaletted(img), nil)
+	case formatQoi:
+		return encodeQoi(w, img)
+	default:
+		if gargs.Optimize_png {
+			return encodeOptimizedPng(w, img)
+		}
+		level, _ := parsePngCompression(gargs.Png_compression)
+		encoder := png.Encoder{CompressionLevel: level}
+		return encoder.Encode(w, img)
+	}
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba
+	}
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgbaToNRGBA(rgba)
+	}
+	bounds := img.Bounds()
+	if paletted, ok := img.(*image.Paletted); ok {
+		// Copy palette entries directly, going through premultiplied colour would round translucent entries.
+		nrgba := image.NewNRGBA(bounds)
+		pal := make([]color.NRGBA, len(paletted.Palette))
+		for i, c := range paletted.Palette {
+			pal[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				nrgba.SetNRGBA(x, y, pal[paletted.ColorIndexAt(x, y)])
+			}
+		}
+		return nrgba
+	}
+	nrgba := image.NewNRGBA(bounds)
+	draw.Draw(nrgba, bounds, img, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// toTransparentPaletted maps img onto the Plan9 palette with index 255 reserved for transparency.
+func toTransparentPaletted(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	pal := make(color.Palette, 0, 256)
+	pal = append(pal, palette.Plan9[:255]...)
+	pal = append(pal, color.Transparent)
+	paletted := image.NewPaletted(bounds, pal)
+	draw.FloydSteinberg.Draw(paletted, bounds, img, bounds.Min)
+	transparent_index := uint8(len(pal) - 1)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				paletted.SetColorIndex(x, y, transparent_index)
+			}
+		}
+	}
+	return paletted
+}
+
+// encodeBmp writes a 32-bit BI_BITFIELDS bitmap with a BITMAPV4HEADER so alpha survives.
+func encodeBmp(w io.Writer, img image.Image) error {
+	nrgba := toNRGBA(img)
+	bounds := nrgba.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	const file_header_size, info_header_size = 14, 108
+	pixel_data_size := width * height * 4
+	offset := file_header_size + info_header_size
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	header := make([]byte, offset)
+	header[0], header[1] = 'B', 'M'
+	le.PutUint32(header[2:], uint32(offset+pixel_data_size))
+	le.PutUint32(header[10:], uint32(offset))
+	info := header[file_header_size:]
+	le.PutUint32(info[0:], info_header_size)
+	le.PutUint32(info[4:], uint32(width))
+	le.PutUint32(info[8:], uint32(height)) // positive height: bottom-up rows
+	le.PutUint16(info[12:], 1)
+	le.PutUint16(info[14:], 32)
+	le.PutUint32(info[16:], 3) // BI_BITFIELDS
+	le.PutUint32(info[20:], uint32(pixel_data_size))
+	le.PutUint32(info[24:], 2835) // 72 DPI
+	le.PutUint32(info[28:], 2835)
+	le.PutUint32(info[40:], 0x00ff0000) // red mask
+	le.PutUint32(info[44:], 0x0000ff00) // green mask
+	le.PutUint32(info[48:], 0x000000ff) // blue mask
+	le.PutUint32(info[52:], 0xff000000) // alpha mask
+	copy(info[56:], "BGRs")             // LCS_sRGB, stored little endian
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]byte, width*4)
+	for y := height - 1; y >= 0; y-- {
+		src := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+width*4]
+		for x := 0; x < width; x++ {
+			row[x*4+0] = src[x*4+2]
+			row[x*4+1] = src[x*4+1]
+			row[x*4+2] = src[x*4+0]
+			row[x*4+3] = src[x*4+3]
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// encodeQoi writes img in the "Quite OK Image" format (https://qoiformat.org/qoi-specification.pdf).
+func encodeQoi(w io.Writer, img image.Image) error {
+	const (
+		qoi_op_index = 0x00
+		qoi_op_diff  = 0x40
+		qoi_op_luma  = 0x80
+		qoi_op_run   = 0xc0
+		qoi_op_rgb   = 0xfe
+		qoi_op_rgba  = 0xff
+	)
+	nrgba := toNRGBA(img)
+	bounds := nrgba.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 14)
+	copy(header, "qoif")
+	binary.BigEndian.PutUint32(header[4:], uint32(width))
+	binary.BigEndian.PutUint32(header[8:], uint32(height))
+	header[12] = 4 // channels: RGBA
+	header[13] = 0 // colorspace: sRGB with linear alpha
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 255}
+	run := 0
+	total := width * height
+	for i := 0; i < total; i++ {
+		x, y := i%width, i/width
+		p := nrgba.Pix[y*nrgba.Stride+x*4 : y*nrgba.Stride+x*4+4]
+		px := [4]byte{p[0], p[1], p[2], p[3]}
+
+		if px == prev {
+			run++
+			if run == 62 || i == total-1 {
+				bw.WriteByte(qoi_op_run | byte(run-1))
+				run = 0
+			}
+			continue
+		}
+		if run > 0 {
+			bw.WriteByte(qoi_op_run | byte(run-1))
+			run = 0
+		}
+
+		hash := (int(px[0])*3 + int(px[1])*5 + int(px[2])*7 + int(px[3])*11) % 64
+		if index[hash] == px {
+			bw.WriteByte(qoi_op_index | byte(hash))
+		} else {
+			index[hash] = px
+			if px[3] == prev[3] {
+				dr := int8(px[0] - prev[0])
+				dg := int8(px[1] - prev[1])
+				db := int8(px[2] - prev[2])
+				dr_dg := dr - dg
+				db_dg := db - dg
+				if dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1 {
+					bw.WriteByte(qoi_op_diff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+				} else if dg >= -32 && dg <= 31 && dr_dg >= -8 && dr_dg <= 7 && db_dg >= -8 && db_dg <= 7 {
+					bw.WriteByte(qoi_op_luma | byte(dg+32))
+					bw.WriteByte(byte(dr_dg+8)<<4 | byte(db_dg+8))
+				} else {
+					bw.Write([]byte{qoi_op_rgb, px[0], px[1], px[2]})
+				}
+			} else {
+				bw.Write([]byte{qoi_op_rgba, px[0], px[1], px[2], px[3]})
+			}
+		}
+		prev = px
+	}
+	bw.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1})
+	return bw.Flush()
+}
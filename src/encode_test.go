@@ -0,0 +1,137 @@
+package gontage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// decodeTestQoi is a straightforward decoder written from the QOI specification.
+func decodeTestQoi(t *testing.T, data []byte) *image.NRGBA {
+	t.Helper()
+	if len(data) < 22 || string(data[:4]) != "qoif" || data[12] != 4 {
+		t.Fatalf("bad qoi header % x", data[:min(len(data), 14)])
+	}
+	if !bytes.Equal(data[len(data)-8:], []byte{0, 0, 0, 0, 0, 0, 0, 1}) {
+		t.Fatalf("missing qoi end marker")
+	}
+	width, height := int(binary.BigEndian.Uint32(data[4:])), int(binary.BigEndian.Uint32(data[8:]))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	pos, run := 14, 0
+	for i := 0; i < width*height*4; i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			op := data[pos]
+			pos++
+			switch {
+			case op == 0xfe:
+				copy(px[:3], data[pos:pos+3])
+				pos += 3
+			case op == 0xff:
+				copy(px[:], data[pos:pos+4])
+				pos += 4
+			case op>>6 == 0:
+				px = index[op]
+			case op>>6 == 1:
+				px[0] += (op>>4)&3 - 2
+				px[1] += (op>>2)&3 - 2
+				px[2] += op&3 - 2
+			case op>>6 == 2:
+				dg := op&0x3f - 32
+				px[0] += dg + data[pos]>>4 - 8
+				px[1] += dg
+				px[2] += dg + data[pos]&0x0f - 8
+				pos++
+			default:
+				run = int(op & 0x3f)
+			}
+			index[(int(px[0])*3+int(px[1])*5+int(px[2])*7+int(px[3])*11)%64] = px
+		}
+		copy(img.Pix[i:i+4], px[:])
+	}
+	if pos != len(data)-8 {
+		t.Fatalf("decoded %d of %d qoi bytes", pos, len(data)-8)
+	}
+	return img
+}
+
+// decodeTestBmp reads back the 32-bit BI_BITFIELDS bitmaps encodeBmp writes.
+func decodeTestBmp(t *testing.T, data []byte) *image.NRGBA {
+	t.Helper()
+	le := binary.LittleEndian
+	if string(data[:2]) != "BM" || le.Uint16(data[28:]) != 32 || le.Uint32(data[30:]) != 3 {
+		t.Fatalf("bad bmp header % x", data[:34])
+	}
+	if int(le.Uint32(data[2:])) != len(data) {
+		t.Fatalf("bmp file size %d, wrote %d bytes", le.Uint32(data[2:]), len(data))
+	}
+	offset := int(le.Uint32(data[10:]))
+	width, height := int(le.Uint32(data[18:])), int(le.Uint32(data[22:]))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		// Rows are stored bottom-up as BGRA.
+		row := data[offset+(height-1-y)*width*4:]
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{row[x*4+2], row[x*4+1], row[x*4], row[x*4+3]})
+		}
+	}
+	return img
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	// One row per QOI op: a run longer than 62 pixels, small and luma sized steps, repeated colours for
+	// the index, and semi-transparent pixels for the alpha channel.
+	full := testImage(70, 5, func(x, y int) color.NRGBA {
+		return []color.NRGBA{
+			{10, 20, 30, 255},
+			{uint8(100 + x), uint8(50 + x), uint8(200 - x), 255},
+			{uint8(x * 37), uint8(x * 11), uint8(x * 23), 255},
+			{uint8(x * 3), 128, uint8(255 - x*3), uint8(x * 255 / 69)},
+			[]color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 128}, {0, 0, 0, 0}}[x%3],
+		}[y]
+	})
+	// A sub image checks the encoders don't assume the bounds start at 0,0.
+	sub := full.SubImage(image.Rect(3, 1, 70, 5)).(*image.NRGBA)
+	for _, tc := range []struct {
+		name   string
+		encode func(buf *bytes.Buffer, img image.Image) error
+		decode func(t *testing.T, data []byte) *image.NRGBA
+	}{
+		{"qoi", func(buf *bytes.Buffer, img image.Image) error { return encodeQoi(buf, img) }, decodeTestQoi},
+		{"bmp", func(buf *bytes.Buffer, img image.Image) error { return encodeBmp(buf, img) }, decodeTestBmp},
+	} {
+		for _, src := range []*image.NRGBA{full, sub} {
+			var buf bytes.Buffer
+			if err := tc.encode(&buf, src); err != nil {
+				t.Fatal(err)
+			}
+			decoded := tc.decode(t, buf.Bytes())
+			bounds := src.Bounds()
+			if decoded.Bounds().Size() != bounds.Size() {
+				t.Fatalf("%s: size %v, want %v", tc.name, decoded.Bounds().Size(), bounds.Size())
+			}
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					if got, want := decoded.NRGBAAt(x, y), src.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y); got != want {
+						t.Fatalf("%s %v: pixel %d,%d = %v, want %v", tc.name, bounds, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestValidateOutputOptionsJpegQuality(t *testing.T) {
+	// -q defaults to 100 in main.go, the library has no unset value to fall back on.
+	for quality, ok := range map[int]bool{0: false, 1: true, 75: true, 100: true, 101: false, -5: false} {
+		err := ValidateOutputOptions(GontageArgs{Jpeg_quality: quality})
+		if (err == nil) != ok {
+			t.Errorf("-q %d: error %v, want ok %v", quality, err, ok)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package gontage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// Fade modes accepted by GontageArgs.Fade_mode (-fm).
+const (
+	fadeCircle  = "c"
+	fadeSquare  = "s"
+	fadeEllipse = "e"
+	fadeRounded = "r"
+	fadeLinear  = "l"
+	fadeMask    = "m"
+)
+
+// fadeDirections are the named -fd directions for the linear fade, as angles in degrees clockwise from
+// pointing right (screen space, y down). Any other -fd value is read as an angle.
+var fadeDirections = map[string]float64{
+	"right": 0,
+	"down":  90,
+	"left":  180,
+	"up":    270,
+}
+
+// isFading reports whether any fade option is set.
+func isFading(gargs GontageArgs) bool {
+	return gargs.Fade_amount > 0 || gargs.Fade_range != "" || gargs.Fade_mask != ""
+}
+
+// fadeMode is the -fm mode in effect, a -fmask always selects the mask mode.
+func fadeMode(gargs GontageArgs) string {
+	if gargs.Fade_mask != "" {
+		return fadeMask
+	}
+	return strings.ToLower(gargs.Fade_mode)
+}
+
+var (
+	fade_mask_mutex sync.Mutex
+	fade_mask       *image.Gray
+	fade_mask_path  string
+	// scaled_fade_masks caches the mask per sprite size, sheets usually have a single size.
+	scaled_fade_masks map[image.Point]*image.Gray
+)
+
+// loadFadeMask decodes the -fmask image as grayscale. Mask alpha counts too, so transparent areas fade out.
+func loadFadeMask(path string) (*image.Gray, error) {
+	fade_mask_mutex.Lock()
+	defer fade_mask_mutex.Unlock()
+	if fade_mask != nil && fade_mask_path == path {
+		return fade_mask, nil
+	}
+	decoded, err := decodeImageFile(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("reading fade mask %s: %v", path, err)
+	}
+	src := toNRGBA(decoded)
+	bounds := src.Bounds()
+	mask := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := src.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			luma := color.GrayModel.Convert(color.NRGBA{c.R, c.G, c.B, 255}).(color.Gray).Y
+			mask.Pix[y*mask.Stride+x] = uint8((uint32(luma)*uint32(c.A) + 127) / 255)
+		}
+	}
+	fade_mask, fade_mask_path = mask, path
+	scaled_fade_masks = map[image.Point]*image.Gray{}
+	return mask, nil
+}
+
+// scaledFadeMask is the -fmask stretched to width x height.
+func scaledFadeMask(path string, width int, height int) (*image.Gray, error) {
+	mask, err := loadFadeMask(path)
+	if err != nil {
+		return nil, err
+	}
+	fade_mask_mutex.Lock()
+	defer fade_mask_mutex.Unlock()
+	size := image.Pt(width, height)
+	if scaled, ok := scaled_fade_masks[size]; ok {
+		return scaled, nil
+	}
+	scaled := mask
+	if mask.Bounds().Size() != size {
+		scaled = resize.Resize(uint(width), uint(height), mask, resize.Bilinear).(*image.Gray)
+	}
+	scaled_fade_masks[size] = scaled
+	return scaled, nil
+}
+
+// parseFadeDirection parses -fd as a named direction or an angle in degrees.
+func parseFadeDirection(direction string) (float64, error) {
+	if direction == "" {
+		return fadeDirections["down"], nil
+	}
+	if angle, ok := fadeDirections[strings.ToLower(direction)]; ok {
+		return angle, nil
+	}
+	angle, err := strconv.ParseFloat(strings.TrimSuffix(direction, "deg"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fade direction %q (expected up, down, left, right or an angle in degrees)", direction)
+	}
+	return angle, nil
+}
+
+// parseFadeRange parses -frange "start,end": the percentages along the -fd direction where the linear fade
+// begins and where it is fully transparent. Without it the fade covers the last -fade percent.
+func parseFadeRange(gargs GontageArgs) (float64, float64, error) {
+	if gargs.Fade_range == "" {
+		return float64(100 - gargs.Fade_amount), 100, nil
+	}
+	parts := strings.Split(gargs.Fade_range, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid fade range %q (expected start,end e.g. 50,100)", gargs.Fade_range)
+	}
+	start, err_start := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	end, err_end := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err_start != nil || err_end != nil || start < 0 || end > 100 || start >= end {
+		return 0, 0, fmt.Errorf("invalid fade range %q (expected start,end with 0 <= start < end <= 100)", gargs.Fade_range)
+	}
+	return start, end, nil
+}
+
+// fadeEasings shape the falloff across the fade band for GontageArgs.Fade_easing (-fe).
+// Each maps the linear progress (0 at the inner edge of the band, 1 at the outer edge) to how faded the pixel is.
+var fadeEasings = map[string]func(float64) float64{
+	"linear": func(p float64) float64 { return p },
+	// smoothstep eases in and out, so neither edge of the band shows a crease.
+	"smoothstep": func(p float64) float64 { return p * p * (3 - 2*p) },
+	// quadratic and cubic drop quickly at first and then tail off towards the outer edge.
+	"quadratic": func(p float64) float64 { return 1 - (1-p)*(1-p) },
+	"cubic":     func(p float64) float64 { return 1 - (1-p)*(1-p)*(1-p) },
+	"exponential": func(p float64) float64 {
+		const k = 5.0
+		return 1 - (math.Exp(-k*p)-math.Exp(-k))/(1-math.Exp(-k))
+	},
+	// gaussian starts flat like a soft particle and is rescaled to reach full transparency at the edge.
+	"gaussian": func(p float64) float64 {
+		const sigma = 0.4
+		tail := math.Exp(-1 / (2 * sigma * sigma))
+		return 1 - (math.Exp(-p*p/(2*sigma*sigma))-tail)/(1-tail)
+	},
+}
+
+func validateFadeOptions(gargs GontageArgs) error {
+	switch strings.ToLower(gargs.Fade_mode) {
+	case "", fadeCircle, fadeSquare, fadeEllipse, fadeRounded, fadeLinear, fadeMask:
+	default:
+		return fmt.Errorf("unknown fade mode %q (expected c, s, e, r, l or m)", gargs.Fade_mode)
+	}
+	if strings.ToLower(gargs.Fade_mode) == fadeMask && gargs.Fade_mask == "" {
+		return fmt.Errorf("-fm m needs a mask image, set it with -fmask")
+	}
+	if gargs.Fade_mask != "" {
+		if _, err := loadFadeMask(gargs.Fade_mask); err != nil {
+			return err
+		}
+	}
+	if _, err := parseFadeDirection(gargs.Fade_direction); err != nil {
+		return err
+	}
+	if _, _, err := parseFadeRange(gargs); err != nil {
+		return err
+	}
+	if gargs.Fade_range != "" && fadeMode(gargs) != fadeLinear {
+		return fmt.Errorf("-frange only applies to the linear fade (-fm l)")
+	}
+	if _, ok := fadeEasings[strings.ToLower(gargs.Fade_easing)]; !ok && gargs.Fade_easing != "" {
+		return fmt.Errorf("unknown fade easing %q (expected linear, smoothstep, quadratic, cubic, exponential or gaussian)", gargs.Fade_easing)
+	}
+	if gargs.Fade_amount < 0 || gargs.Fade_amount > 100 {
+		return fmt.Errorf("fade amount %d out of range (expected 0-100)", gargs.Fade_amount)
+	}
+	if gargs.Fade_corner_radius < 0 || gargs.Fade_corner_radius > 100 {
+		return fmt.Errorf("fade corner radius %d out of range (expected 0-100)", gargs.Fade_corner_radius)
+	}
+	return nil
+}
+
+// fadeShape works out how far into the fade band a pixel is for the -fm mode.
+type fadeShape struct {
+	mode     string
+	amount   float64
+	center_x float64
+	center_y float64
+	// corner_radius is in px, only used by the rounded rectangle.
+	corner_radius float64
+	// cos, sin, start and end describe the linear fade, start and end as fractions along the direction.
+	cos   float64
+	sin   float64
+	start float64
+	end   float64
+	// mask is the -fmask scaled to the image size.
+	mask *image.Gray
+	ease func(float64) float64
+}
+
+func newFadeShape(bounds image.Rectangle, gargs GontageArgs) fadeShape {
+	shape := fadeShape{
+		mode:     fadeMode(gargs),
+		amount:   float64(gargs.Fade_amount) / 100.0,
+		center_x: float64(bounds.Dx()) / 2.0,
+		center_y: float64(bounds.Dy()) / 2.0,
+	}
+	shape.corner_radius = math.Min(shape.center_x, shape.center_y) * float64(gargs.Fade_corner_radius) / 100.0
+	angle, _ := parseFadeDirection(gargs.Fade_direction)
+	shape.sin, shape.cos = math.Sincos(angle * math.Pi / 180)
+	start, end, _ := parseFadeRange(gargs)
+	shape.start, shape.end = start/100, end/100
+	if shape.mode == fadeMask {
+		// The mask was loaded when validating the options, so this can't fail.
+		shape.mask, _ = scaledFadeMask(gargs.Fade_mask, bounds.Dx(), bounds.Dy())
+	}
+	shape.ease = fadeEasings[strings.ToLower(gargs.Fade_easing)]
+	if shape.ease == nil {
+		shape.ease = fadeEasings["linear"]
+	}
+	return shape
+}
+
+// alpha is the multiplier for the pixel's alpha at x, y after easing.
+func (shape fadeShape) alpha(x float64, y float64) float64 {
+	return 1 - shape.ease(shape.progress(x, y))
+}
+
+// progress is 0 inside the unfaded area, rising to 1 at the edge of the shape and beyond.
+// x and y are relative to the top-left of the image.
+func (shape fadeShape) progress(x float64, y float64) float64 {
+	dx := math.Abs(x - shape.center_x)
+	dy := math.Abs(y - shape.center_y)
+	var progress float64
+	switch shape.mode {
+	case fadeSquare:
+		fade_distance_x := shape.center_x * shape.amount
+		fade_distance_y := shape.center_y * shape.amount
+		x_progress := (dx - (shape.center_x - fade_distance_x)) / fade_distance_x
+		y_progress := (dy - (shape.center_y - fade_distance_y)) / fade_distance_y
+		progress = math.Max(x_progress, y_progress)
+	case fadeEllipse:
+		// Normalising by each half axis makes the ellipse follow the sprite's aspect ratio.
+		distance := math.Sqrt((dx/shape.center_x)*(dx/shape.center_x) + (dy/shape.center_y)*(dy/shape.center_y))
+		progress = (distance - (1 - shape.amount)) / shape.amount
+	case fadeMask:
+		// White keeps the sprite, black fades it out completely.
+		progress = 1 - float64(shape.mask.GrayAt(int(x), int(y)).Y)/255
+	case fadeLinear:
+		// Project the pixel centre onto the direction, scaled so the image spans 0 to 1 whatever the angle.
+		half_extent := math.Abs(shape.center_x*shape.cos) + math.Abs(shape.center_y*shape.sin)
+		along := ((x+0.5-shape.center_x)*shape.cos + (y+0.5-shape.center_y)*shape.sin) / half_extent
+		progress = ((along+1)/2 - shape.start) / (shape.end - shape.start)
+	case fadeRounded:
+		// Signed distance to a rounded rectangle filling the image, negative inside.
+		qx := dx - (shape.center_x - shape.corner_radius)
+		qy := dy - (shape.center_y - shape.corner_radius)
+		outside := math.Hypot(math.Max(qx, 0), math.Max(qy, 0))
+		distance := outside + math.Min(math.Max(qx, qy), 0) - shape.corner_radius
+		fade_distance := math.Min(shape.center_x, shape.center_y) * shape.amount
+		progress = 1 + distance/fade_distance
+	default:
+		max_radius := math.Min(shape.center_x, shape.center_y)
+		fade_radius := max_radius * shape.amount
+		progress = (math.Sqrt(dx*dx+dy*dy) - (max_radius - fade_radius)) / fade_radius
+	}
+	return math.Max(0, math.Min(1, progress))
+}
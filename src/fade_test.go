@@ -0,0 +1,405 @@
+package gontage
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update_golden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const golden_faded_sprite = "testdata/faded_sprite.png"
+
+// fadeTestSprite is an opaque 16x16 sprite where every pixel has a different colour.
+func fadeTestSprite() *image.NRGBA {
+	return testImage(16, 16, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x * 16), uint8(y * 16), 200, 255} })
+}
+
+func fadeTestArgs() GontageArgs {
+	return GontageArgs{
+		Hframes:              1,
+		Sprite_resize_width:  16,
+		Sprite_resize_height: 16,
+		// Whole-factor pixel scaling at 1x copies the sprite exactly, so only the fade changes it.
+		Resize_filter: filterPixelArt,
+		Fade_amount:   60,
+		Fade_mode:     fadeCircle,
+		Jpeg_quality:  100,
+	}
+}
+
+// checkFadedSprite compares a faded sprite with the golden file and checks that every visible pixel
+// still has the source colour, whatever its alpha.
+func checkFadedSprite(t *testing.T, faded *image.NRGBA) {
+	t.Helper()
+	source := fadeTestSprite()
+	golden := readTestPng(t, golden_faded_sprite)
+	bounds := faded.Bounds()
+	if bounds.Size() != golden.Bounds().Size() {
+		t.Fatalf("size %v, want %v", bounds.Size(), golden.Bounds().Size())
+	}
+	partial := 0
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			got := faded.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			if want := golden.NRGBAAt(x, y); got != want {
+				t.Errorf("pixel %d,%d = %v, golden %v", x, y, got, want)
+			}
+			want_colour := source.NRGBAAt(x, y)
+			if got.A > 0 && (got.R != want_colour.R || got.G != want_colour.G || got.B != want_colour.B) {
+				t.Errorf("pixel %d,%d with alpha %d = %v, want the source colour %v", x, y, got.A, got, want_colour)
+			}
+			if got.A > 0 && got.A < 255 {
+				partial++
+			}
+		}
+	}
+	if partial == 0 {
+		t.Fatalf("expected partially faded pixels")
+	}
+}
+
+func TestApplyFadingGolden(t *testing.T) {
+	faded := applyFading(fadeTestSprite(), fadeTestArgs())
+	if *update_golden {
+		writeTestPng(t, golden_faded_sprite, faded)
+	}
+	checkFadedSprite(t, faded)
+}
+
+func TestApplyFadingOffsetBounds(t *testing.T) {
+	// Cut cells keep their position on the sheet, the fade must still be centred on the cell.
+	sheet := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	draw.Draw(sheet, image.Rect(16, 0, 32, 16), fadeTestSprite(), image.Point{}, draw.Src)
+	cell := sheet.SubImage(image.Rect(16, 0, 32, 16))
+	checkFadedSprite(t, applyFading(cell, fadeTestArgs()))
+}
+
+// inTempDir runs fn with the working directory set to a new temporary directory,
+// Gontage reads -f relative to the working directory.
+func inTempDir(t *testing.T, fn func()) {
+	t.Helper()
+	golden, err := filepath.Abs(golden_faded_sprite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previous)
+	// Keep the golden file reachable from the temporary directory.
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(golden_faded_sprite, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fn()
+}
+
+func TestFadedOutputsKeepColour(t *testing.T) {
+	t.Run("spritesheet", func(t *testing.T) {
+		inTempDir(t, func() {
+			os.Mkdir("sprites", 0755)
+			writeTestPng(t, filepath.Join("sprites", "0.png"), fadeTestSprite())
+			gargs := fadeTestArgs()
+			gargs.Sprite_source_folder = "sprites"
+			Gontage(gargs)
+			checkFadedSprite(t, readTestPng(t, "sprites_f1_v1.png"))
+		})
+	})
+	t.Run("single sprites", func(t *testing.T) {
+		inTempDir(t, func() {
+			os.Mkdir("sprites", 0755)
+			writeTestPng(t, filepath.Join("sprites", "0.png"), fadeTestSprite())
+			gargs := fadeTestArgs()
+			gargs.Sprite_source_folder = "sprites"
+			gargs.Single_sprites = true
+			Gontage(gargs)
+			checkFadedSprite(t, readTestPng(t, filepath.Join("sprites_resized_16px", "0.png")))
+		})
+	})
+	t.Run("single image", func(t *testing.T) {
+		inTempDir(t, func() {
+			writeTestPng(t, "sprite.png", fadeTestSprite())
+			gargs := fadeTestArgs()
+			gargs.Image_path = "sprite.png"
+			ResizeSingleImage(gargs)
+			checkFadedSprite(t, readTestPng(t, "sprite_resized_16px.png"))
+		})
+	})
+	t.Run("cut", func(t *testing.T) {
+		inTempDir(t, func() {
+			sheet := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+			draw.Draw(sheet, image.Rect(0, 0, 16, 16), fadeTestSprite(), image.Point{}, draw.Src)
+			draw.Draw(sheet, image.Rect(16, 0, 32, 16), fadeTestSprite(), image.Point{}, draw.Src)
+			os.Mkdir("sheets", 0755)
+			writeTestPng(t, filepath.Join("sheets", "sheet.png"), sheet)
+			gargs := fadeTestArgs()
+			gargs.Sprite_source_folder = "sheets"
+			gargs.Cut_spritesheet = "16x16"
+			Gontage(gargs)
+			checkFadedSprite(t, readTestPng(t, filepath.Join("sheets", "sheet", "0.png")))
+			checkFadedSprite(t, readTestPng(t, filepath.Join("sheets", "sheet", "1.png")))
+		})
+	})
+}
+
+// checkFadeProgress compares shape.progress at each point with want.
+func checkFadeProgress(t *testing.T, name string, shape fadeShape, want map[[2]float64]float64) {
+	t.Helper()
+	for p, progress := range want {
+		if got := shape.progress(p[0], p[1]); math.Abs(got-progress) > 1e-9 {
+			t.Errorf("%s: progress at %v = %v, want %v", name, p, got, progress)
+		}
+	}
+}
+
+func TestFadeShapeEllipseAndRounded(t *testing.T) {
+	// A 40x20 sprite: the centre is 20,10 and the half axes are 20 and 10.
+	bounds := image.Rect(0, 0, 40, 20)
+	ellipse := newFadeShape(bounds, GontageArgs{Fade_amount: 50, Fade_mode: fadeEllipse})
+	checkFadeProgress(t, "ellipse", ellipse, map[[2]float64]float64{
+		{20, 10}: 0,
+		// Half way along either half axis is where the 50% band starts.
+		{30, 10}: 0, {20, 5}: 0,
+		{35, 10}: 0.5, {20, 2.5}: 0.5,
+		{40, 10}: 1, {20, 0}: 1, {0, 0}: 1,
+	})
+	// The circle fits the short side, so the same point is already gone.
+	circle := newFadeShape(bounds, GontageArgs{Fade_amount: 50, Fade_mode: fadeCircle})
+	checkFadeProgress(t, "circle", circle, map[[2]float64]float64{{35, 10}: 1})
+
+	// The rounded rectangle band is 50% of the short half side (5px) wide on every edge.
+	square := newFadeShape(bounds, GontageArgs{Fade_amount: 50, Fade_mode: fadeRounded})
+	checkFadeProgress(t, "rounded -fr 0", square, map[[2]float64]float64{
+		{20, 10}: 0, {34, 10}: 0, {20, 6}: 0,
+		{37.5, 10}: 0.5, {20, 2.5}: 0.5, {37, 3}: 0.4,
+		{40, 10}: 1, {20, 0}: 1,
+	})
+	// -fr 100 rounds the corners with a 10px radius, which fades them out sooner than the straight edges.
+	rounded := newFadeShape(bounds, GontageArgs{Fade_amount: 50, Fade_mode: fadeRounded, Fade_corner_radius: 100})
+	checkFadeProgress(t, "rounded -fr 100", rounded, map[[2]float64]float64{
+		{20, 10}: 0, {37.5, 10}: 0.5, {20, 2.5}: 0.5,
+		{37, 3}: 1 + (math.Hypot(7, 7)-10)/5,
+		{40, 0}: 1,
+	})
+}
+
+func TestApplyFadingEllipseAndRoundedNonSquare(t *testing.T) {
+	sprite := solidTestImage(40, 20, color.NRGBA{255, 255, 255, 255})
+	for _, mode := range []string{fadeEllipse, fadeRounded} {
+		faded := applyFading(sprite, GontageArgs{Fade_amount: 50, Fade_mode: mode, Fade_corner_radius: 50})
+		// Opaque in the middle of the long side, faded towards both ends, gone at the corners.
+		if a := faded.NRGBAAt(20, 10).A; a != 255 {
+			t.Errorf("%s: centre alpha %d, want 255", mode, a)
+		}
+		if a := faded.NRGBAAt(30, 10).A; a != 255 {
+			t.Errorf("%s: alpha %d at 30,10, want 255", mode, a)
+		}
+		if a := faded.NRGBAAt(37, 10).A; a == 0 || a == 255 {
+			t.Errorf("%s: alpha %d at 37,10, want partly faded", mode, a)
+		}
+		if a := faded.NRGBAAt(0, 0).A; a != 0 {
+			t.Errorf("%s: corner alpha %d, want 0", mode, a)
+		}
+	}
+}
+
+func TestValidateFadeCornerRadius(t *testing.T) {
+	for radius, ok := range map[int]bool{-1: false, 0: true, 50: true, 100: true, 101: false} {
+		err := validateFadeOptions(GontageArgs{Fade_amount: 50, Fade_mode: fadeRounded, Fade_corner_radius: radius})
+		if (err == nil) != ok {
+			t.Errorf("-fr %d: error %v, want ok %v", radius, err, ok)
+		}
+	}
+}
+
+func TestParseFadeDirection(t *testing.T) {
+	for _, tc := range []struct {
+		direction string
+		angle     float64
+		ok        bool
+	}{
+		{"", 90, true},
+		{"right", 0, true},
+		{"Down", 90, true},
+		{"LEFT", 180, true},
+		{"up", 270, true},
+		{"45", 45, true},
+		{"30deg", 30, true},
+		{"-90", -90, true},
+		{"north", 0, false},
+		{"deg", 0, false},
+	} {
+		angle, err := parseFadeDirection(tc.direction)
+		if (err == nil) != tc.ok || angle != tc.angle {
+			t.Errorf("parseFadeDirection(%q) = %v, %v, want %v, ok %v", tc.direction, angle, err, tc.angle, tc.ok)
+		}
+	}
+}
+
+func TestParseFadeRange(t *testing.T) {
+	for _, tc := range []struct {
+		gargs      GontageArgs
+		start, end float64
+		ok         bool
+	}{
+		{GontageArgs{Fade_amount: 30}, 70, 100, true},
+		{GontageArgs{Fade_range: "20, 80"}, 20, 80, true},
+		{GontageArgs{Fade_range: "0,100", Fade_amount: 30}, 0, 100, true},
+		{GontageArgs{Fade_range: "12.5,50"}, 12.5, 50, true},
+		{GontageArgs{Fade_range: "80,20"}, 0, 0, false},
+		{GontageArgs{Fade_range: "50,50"}, 0, 0, false},
+		{GontageArgs{Fade_range: "-1,50"}, 0, 0, false},
+		{GontageArgs{Fade_range: "50,101"}, 0, 0, false},
+		{GontageArgs{Fade_range: "50"}, 0, 0, false},
+		{GontageArgs{Fade_range: "a,b"}, 0, 0, false},
+	} {
+		start, end, err := parseFadeRange(tc.gargs)
+		if (err == nil) != tc.ok || start != tc.start || end != tc.end {
+			t.Errorf("parseFadeRange(%q, -fade %d) = %v, %v, %v, want %v, %v, ok %v",
+				tc.gargs.Fade_range, tc.gargs.Fade_amount, start, end, err, tc.start, tc.end, tc.ok)
+		}
+	}
+	if err := validateFadeOptions(GontageArgs{Fade_range: "20,80", Fade_mode: fadeCircle}); err == nil {
+		t.Errorf("expected an error for -frange without -fm l")
+	}
+	if err := validateFadeOptions(GontageArgs{Fade_range: "20,80", Fade_mode: fadeLinear}); err != nil {
+		t.Errorf("-fm l -frange 20,80: %v", err)
+	}
+}
+
+func TestFadeShapeLinear(t *testing.T) {
+	// A 20x10 sprite, progress is measured at pixel centres.
+	bounds := image.Rect(0, 0, 20, 10)
+	for _, tc := range []struct {
+		direction string
+		fade      string
+		want      map[[2]float64]float64
+	}{
+		// -fade 50 fades the last half. The bottom row centre is 95% of the way down, the last column 97.5% across.
+		{"down", "", map[[2]float64]float64{{0, 0}: 0, {0, 4}: 0, {19, 7}: 0.5, {0, 9}: 0.9}},
+		{"up", "", map[[2]float64]float64{{0, 9}: 0, {0, 2}: 0.5, {19, 0}: 0.9}},
+		{"right", "", map[[2]float64]float64{{9, 5}: 0, {14.5, 0}: 0.5, {19, 9}: 0.95}},
+		{"left", "50,100", map[[2]float64]float64{{10, 5}: 0, {0, 0}: 0.95}},
+		// -frange 25,75 starts a quarter of the way across and is transparent three quarters across.
+		{"right", "25,75", map[[2]float64]float64{{4, 0}: 0, {9.5, 0}: 0.5, {14.5, 0}: 1, {19, 0}: 1}},
+		// 45 degrees spans corner to corner, whatever the aspect ratio.
+		{"45", "0,100", map[[2]float64]float64{{0, 0}: 1.0 / 30, {19, 9}: 29.0 / 30, {0, 9}: 1.0 / 3, {19, 0}: 2.0 / 3}},
+	} {
+		gargs := GontageArgs{Fade_mode: fadeLinear, Fade_direction: tc.direction, Fade_amount: 50, Fade_range: tc.fade}
+		checkFadeProgress(t, "-fd "+tc.direction+" -frange "+tc.fade, newFadeShape(bounds, gargs), tc.want)
+	}
+}
+
+func TestFadeMask(t *testing.T) {
+	dir := t.TempDir()
+	// White keeps, black fades, fully transparent counts as black and half transparent white as grey.
+	mask := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	mask.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	mask.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 255})
+	mask.SetNRGBA(0, 1, color.NRGBA{255, 255, 255, 0})
+	mask.SetNRGBA(1, 1, color.NRGBA{255, 255, 255, 128})
+	mask_path := filepath.Join(dir, "mask.png")
+	writeTestPng(t, mask_path, mask)
+
+	loaded, err := loadFadeMask(mask_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loaded.Pix, []uint8{255, 0, 0, 128}; string(got) != string(want) {
+		t.Errorf("mask %v, want %v", got, want)
+	}
+
+	// The decoded mask and each stretched size are cached until another mask is loaded.
+	if again, _ := loadFadeMask(mask_path); again != loaded {
+		t.Errorf("loading the same mask again decoded it again")
+	}
+	same_size, _ := scaledFadeMask(mask_path, 2, 2)
+	if same_size != loaded {
+		t.Errorf("a mask the size of the sprite should be used as it is")
+	}
+	stretched, err := scaledFadeMask(mask_path, 20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := scaledFadeMask(mask_path, 20, 10); again != stretched {
+		t.Errorf("the stretched mask wasn't cached")
+	}
+	if size := stretched.Bounds().Size(); size != image.Pt(20, 10) {
+		t.Fatalf("stretched mask size %v, want 20x10", size)
+	}
+
+	// A 20x10 sprite takes the stretched mask, whatever -fm says.
+	sprite := solidTestImage(20, 10, color.NRGBA{255, 255, 255, 255})
+	faded := applyFading(sprite, GontageArgs{Fade_mask: mask_path, Fade_mode: fadeCircle})
+	for _, tc := range []struct {
+		x, y     int
+		min, max uint8
+	}{
+		{0, 0, 250, 255},
+		{19, 0, 0, 5},
+		{0, 9, 0, 5},
+		{19, 9, 120, 136},
+	} {
+		if a := faded.NRGBAAt(tc.x, tc.y).A; a < tc.min || a > tc.max {
+			t.Errorf("alpha at %d,%d = %d, want %d-%d", tc.x, tc.y, a, tc.min, tc.max)
+		}
+	}
+
+	other_path := filepath.Join(dir, "other.png")
+	writeTestPng(t, other_path, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	if other, _ := loadFadeMask(other_path); other == loaded || len(scaled_fade_masks) != 0 {
+		t.Errorf("loading another mask should replace the cached one and its stretched sizes")
+	}
+	if err := validateFadeOptions(GontageArgs{Fade_mode: fadeMask}); err == nil {
+		t.Errorf("expected an error for -fm m without -fmask")
+	}
+	if err := validateFadeOptions(GontageArgs{Fade_mask: filepath.Join(dir, "missing.png")}); err == nil {
+		t.Errorf("expected an error for a missing -fmask")
+	}
+}
+
+func TestFadeEasings(t *testing.T) {
+	for name, ease := range fadeEasings {
+		// 0 is the inner edge of the band and stays opaque, 1 the outer edge and is fully transparent.
+		if got := ease(0); math.Abs(got) > 1e-12 {
+			t.Errorf("%s(0) = %v, want 0", name, got)
+		}
+		if got := ease(1); math.Abs(got-1) > 1e-12 {
+			t.Errorf("%s(1) = %v, want 1", name, got)
+		}
+		previous := ease(0)
+		for i := 1; i <= 1000; i++ {
+			got := ease(float64(i) / 1000)
+			if got < previous-1e-12 || got < -1e-12 || got > 1+1e-12 {
+				t.Fatalf("%s(%v) = %v after %v, want it rising from 0 to 1", name, float64(i)/1000, got, previous)
+			}
+			previous = got
+		}
+		// Through a fade shape that is alpha 1 at the centre and 0 at the edge of the circle.
+		shape := newFadeShape(image.Rect(0, 0, 20, 20), GontageArgs{Fade_amount: 50, Fade_easing: name})
+		if inner, outer := shape.alpha(10, 10), shape.alpha(20, 10); inner != 1 || math.Abs(outer) > 1e-12 {
+			t.Errorf("%s: alpha %v at the centre and %v at the edge, want 1 and 0", name, inner, outer)
+		}
+		// Every easing other than linear bends the curve.
+		if name != "linear" && math.Abs(ease(0.25)-0.25) < 0.01 {
+			t.Errorf("%s(0.25) = %v, the same as linear", name, ease(0.25))
+		}
+	}
+}
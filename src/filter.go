@@ -0,0 +1,138 @@
+package gontage
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// sourceImageExts are the files gontage can decode, anything else in a sprite folder
+// (Thumbs.db, .psd, README, .backup files left by -fix-png, metadata) is ignored.
+var sourceImageExts = func() map[string]bool {
+	exts := map[string]bool{
+		".png": true,
+		".gif": true,
+		".tga": true,
+	}
+	// Every JPEG extension encode.go knows about, so .jfif and friends load like they always did.
+	for _, ext := range jpegExts {
+		exts["."+ext] = true
+	}
+	return exts
+}()
+
+// regexPrefix marks an -include/-exclude pattern as a regular expression instead of a glob.
+const regexPrefix = "re:"
+
+// filePattern is one -include/-exclude pattern, matched against the file name only.
+type filePattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+func (pattern filePattern) match(name string) bool {
+	if pattern.regex != nil {
+		return pattern.regex.MatchString(name)
+	}
+	matched, _ := filepath.Match(pattern.glob, name)
+	return matched
+}
+
+// parseFilePatterns parses comma separated patterns such as "walk_*.png,re:^idle_\d+\.png$".
+func parseFilePatterns(patterns string) ([]filePattern, error) {
+	var parsed []filePattern
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if expression, is_regex := strings.CutPrefix(pattern, regexPrefix); is_regex {
+			regex, err := regexp.Compile(expression)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %q: %v", expression, err)
+			}
+			parsed = append(parsed, filePattern{regex: regex})
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+		parsed = append(parsed, filePattern{glob: pattern})
+	}
+	return parsed, nil
+}
+
+// sourceFilter decides which files in a sprite folder are decoded.
+type sourceFilter struct {
+	include []filePattern
+	exclude []filePattern
+}
+
+func newSourceFilter(gargs GontageArgs) (sourceFilter, error) {
+	include, err := parseFilePatterns(gargs.Include_files)
+	if err != nil {
+		return sourceFilter{}, fmt.Errorf("-include: %v", err)
+	}
+	exclude, err := parseFilePatterns(gargs.Exclude_files)
+	if err != nil {
+		return sourceFilter{}, fmt.Errorf("-exclude: %v", err)
+	}
+	return sourceFilter{include: include, exclude: exclude}, nil
+}
+
+// keep reports whether name is an image that passes the -include and -exclude patterns.
+// Hidden files are always skipped.
+func (filter sourceFilter) keep(name string) bool {
+	if strings.HasPrefix(name, ".") || !sourceImageExts[strings.ToLower(filepath.Ext(name))] {
+		return false
+	}
+	for _, pattern := range filter.exclude {
+		if pattern.match(name) {
+			return false
+		}
+	}
+	if len(filter.include) == 0 {
+		return true
+	}
+	for _, pattern := range filter.include {
+		if pattern.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSourceFilters checks the -include and -exclude patterns.
+func ValidateSourceFilters(gargs GontageArgs) error {
+	_, err := newSourceFilter(gargs)
+	return err
+}
+
+// IsSourceFile reports whether the file name would be decoded from a sprite folder.
+func IsSourceFile(name string, gargs GontageArgs) bool {
+	filter, err := newSourceFilter(gargs)
+	if err != nil {
+		// Patterns are validated up front, fall back to the default ignore list.
+		filter = sourceFilter{}
+	}
+	return filter.keep(name)
+}
+
+// FilterSourceFiles keeps the image files of a sprite folder that pass the -include and -exclude patterns,
+// dropping sub folders and everything else.
+func FilterSourceFiles(entries []fs.DirEntry, gargs GontageArgs) []fs.DirEntry {
+	filter, err := newSourceFilter(gargs)
+	if err != nil {
+		// Patterns are validated up front, fall back to the default ignore list.
+		filter = sourceFilter{}
+	}
+	var kept []fs.DirEntry
+	for _, entry := range entries {
+		if !entry.IsDir() && filter.keep(entry.Name()) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,39 @@
+package gontage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFilterSourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a.png", "b.JPG", "c.jfif", "d.pjpeg", "e.pjp", "f.tga", "g.gif",
+		"Thumbs.db", "h.psd", ".hidden.png", "i.png.backup", "skip_j.png",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.png"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gargs := GontageArgs{Exclude_files: "skip_*"}
+	var kept []string
+	for _, entry := range FilterSourceFiles(entries, gargs) {
+		kept = append(kept, entry.Name())
+	}
+	want := []string{"a.png", "b.JPG", "c.jfif", "d.pjpeg", "e.pjp", "f.tga", "g.gif"}
+	if !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if !IsSourceFile("walk.jfif", gargs) || IsSourceFile("skip_walk.jfif", gargs) {
+		t.Errorf("IsSourceFile disagrees with FilterSourceFiles on .jfif files")
+	}
+}
@@ -4,24 +4,19 @@ import (
 	"fmt"
 	"hash/crc32"
 	"image"
-	"image/draw"
-	"image/jpeg"
 	"image/png"
+	"io"
 	"io/fs"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"image/color"
-
 	"github.com/dblezek/tga"
-	"github.com/nfnt/resize"
 )
 
 type drawingInfo struct {
@@ -29,26 +24,88 @@ type drawingInfo struct {
 	hframes               int
 	vframes               int
 	vertical_frames_count int
-	spritesheet           draw.Image
+	spritesheet           *image.NRGBA
 }
 
 type GontageArgs struct {
-	Sprite_source_folder    string
-	Image_path              string
-	Hframes                 int
-	Sprite_resize_px_resize int
-	Fade_amount             int
-	Fade_mode               string
-	Single_sprites          bool
-	Cut_spritesheet         string
-	Convert_sprites         string
-	Cpu_threads             int
-	Fix_png_checksum        bool
+	Sprite_source_folder string
+	Image_path           string
+	Hframes              int
+	Sprite_resize_width  int
+	Sprite_resize_height int
+	Resize_mode          string
+	Resize_filter        string
+	Linear_resize        bool
+	Fade_amount          int
+	Fade_mode            string
+	Fade_corner_radius   int
+	Fade_easing          string
+	Fade_direction       string
+	Fade_range           string
+	Fade_mask            string
+	Outline_width        int
+	Outline_color        string
+	Outline_position     string
+	Outline_antialias    bool
+	Shadow_offset        string
+	Shadow_blur          int
+	Shadow_color         string
+	Shadow_opacity       int
+	Glow_radius          int
+	Glow_color           string
+	Glow_opacity         int
+	Recolor_source       string
+	Recolor_targets      string
+	Recolor_tolerance    int
+	Single_sprites       bool
+	Cut_spritesheet      string
+	Convert_sprites      string
+	Cpu_threads          int
+	Fix_png_checksum     bool
+	Output_format        string
+	Png_compression      string
+	Jpeg_quality         int
+	Palette_colors       int
+	Quantizer            string
+	Dither               string
+	Optimize_png         bool
+	Scales               []float64
+	Write_metadata       bool
+	Skip_empty           bool
+	Cut_margin           int
+	Cut_spacing          int
+	Cut_offset           string
+	Cut_grid             string
+	Cut_background       string
+	Merge_distance       int
+	Name_template        string
+	Frame_order          string
+	Include_files        string
+	Exclude_files        string
+}
+
+// validateArgs runs the option checks in order and returns the first error, before any work is done.
+func validateArgs(gargs GontageArgs, validators ...func(GontageArgs) error) error {
+	for _, validate := range validators {
+		if err := validate(gargs); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func Gontage(gargs GontageArgs) {
-	// sprite_source_folder string, hframes *int, sprite_resize_px_resize int, single_sprites bool, cut_spritesheet bool
 	start := time.Now()
+	err := validateArgs(gargs, ValidateOutputOptions, validateResizeOptions, validateFadeOptions,
+		validateEffectOptions, validateNameTemplate, validateRecolorOptions, ValidateSourceFilters)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if isAtlasFile(gargs.Cut_spritesheet) {
+		unpackAtlas(gargs, start)
+		return
+	}
 	pwd, err := os.Getwd()
 	if err != nil {
 		fmt.Println(err)
@@ -62,7 +119,12 @@ func Gontage(gargs GontageArgs) {
 	} else if len(sprites_folder) == 0 {
 		fmt.Println("Looks like folder ", gargs.Sprite_source_folder, "is empty...")
 	}
-	sprites_folder = cleanSpritesFolder(sprites_folder)
+	sprites_folder = FilterSourceFiles(sprites_folder, gargs)
+	sprites_folder, err = sortSpritesFolder(sprites_folder, gargs)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
 	if len(sprites_folder) < gargs.Hframes {
 		gargs.Hframes = len(sprites_folder)
@@ -91,8 +153,9 @@ func Gontage(gargs GontageArgs) {
 
 			chunk_images_waitgroup.Add(1)
 			go func(start int, end int) {
-				// Ideally decodeImages would write into all_decoded_images directly.
-				one_chunk_of_decoded_images, decoded_image_names := decodeImages(sprites_folder[start:end], gargs.Sprite_source_folder, pwd, &chunk_images_waitgroup, gargs.Fade_amount, gargs.Fade_mode, gargs.Fix_png_checksum)
+				// Only signal once the chunk is copied in, Wait must not return while the slices are still being written.
+				defer chunk_images_waitgroup.Done()
+				one_chunk_of_decoded_images, decoded_image_names := decodeImages(sprites_folder[start:end], gargs.Sprite_source_folder, pwd, gargs)
 				for j, decoded_image := range one_chunk_of_decoded_images {
 					all_decoded_images[start+j] = decoded_image
 					all_decoded_images_names[start+j] = decoded_image_names[j]
@@ -101,9 +164,11 @@ func Gontage(gargs GontageArgs) {
 		}
 		chunk_images_waitgroup.Wait()
 
-		if gargs.Single_sprites {
+		if isRecoloring(gargs) {
+			recolorVariants(gargs, all_decoded_images, all_decoded_images_names, start)
+		} else if gargs.Single_sprites {
 			spritesToResizedSprites(gargs, all_decoded_images, all_decoded_images_names, start)
-		} else if gargs.Cut_spritesheet != "" {
+		} else if gargs.Cut_spritesheet != "" || gargs.Cut_grid != "" {
 			cutSpritesheetIntoSprites(gargs, all_decoded_images, all_decoded_images_names, start)
 		} else {
 			spritesToSpritesheet(gargs, all_decoded_images, all_decoded_images_names, start)
@@ -111,22 +176,7 @@ func Gontage(gargs GontageArgs) {
 	}
 }
 
-func cleanSpritesFolder(sprites_folder []fs.DirEntry) []fs.DirEntry {
-	var temp_sprites_folder []fs.DirEntry
-	for _, sprite := range sprites_folder {
-		switch filepath.Ext(sprite.Name()) {
-		case ".meta":
-			continue
-		default:
-			temp_sprites_folder = append(temp_sprites_folder, sprite)
-		}
-	}
-	sprites_folder = temp_sprites_folder
-	return sprites_folder
-}
-
-func decodeImages(sprites_folder []fs.DirEntry, targetFolder string, pwd string, wg *sync.WaitGroup, fadeAmount int, fadeMode string, fixPngChecksum bool) ([]image.Image, []string) {
-	defer wg.Done()
+func decodeImages(sprites_folder []fs.DirEntry, targetFolder string, pwd string, gargs GontageArgs) ([]image.Image, []string) {
 	var sprites_array []image.Image
 	var sprites_names []string
 	for _, sprite := range sprites_folder {
@@ -148,7 +198,7 @@ func decodeImages(sprites_folder []fs.DirEntry, targetFolder string, pwd string,
 					if err != nil {
 						reader.Close()
 						// Try to fix PNG checksum errors if enabled and file is PNG
-						if fixPngChecksum && strings.ToLower(filepath.Ext(sprite.Name())) == ".png" {
+						if gargs.Fix_png_checksum && strings.ToLower(filepath.Ext(sprite.Name())) == ".png" {
 							if fixErr := FixPngChecksum(imagePath); fixErr != nil {
 								log.Fatalf("Failed to fix PNG checksum for %s: %v (original error: %v)", imagePath, fixErr, err)
 							}
@@ -173,11 +223,6 @@ func decodeImages(sprites_folder []fs.DirEntry, targetFolder string, pwd string,
 					}
 				}
 
-				// Apply fading if specified
-				if fadeAmount > 0 {
-					decoded_sprite = applyFading(decoded_sprite, fadeAmount, fadeMode)
-				}
-
 				sprites_array = append(sprites_array, decoded_sprite)
 				sprites_names = append(sprites_names, sprite.Name())
 			}
@@ -193,10 +238,40 @@ func drawSpritesheet(drawing drawingInfo) {
 		x0, y0 := horizontal_frames_count*width, drawing.vertical_frames_count*height
 		x1, y1 := width*drawing.hframes, height*drawing.vframes
 		r := image.Rect(x0, y0, x1, y1)
-		draw.Draw(drawing.spritesheet, r, sprite_image, image.Point{}, draw.Over)
+		// Cells don't overlap and the sheet starts transparent, so Src copies straight alpha without compositing.
+		drawNRGBA(drawing.spritesheet, r, sprite_image, image.Point{})
 	}
 }
 
+// resizeSprites resizes every sprite concurrently, keeping their order.
+func resizeSprites(gargs GontageArgs, all_decoded_images []image.Image) []image.Image {
+	resized_images := make([]image.Image, len(all_decoded_images))
+	var resize_wg sync.WaitGroup
+	for i, decoded_image := range all_decoded_images {
+		resize_wg.Add(1)
+		go func(i int, decoded_image image.Image) {
+			defer resize_wg.Done()
+			resized_images[i] = resizeSprite(decoded_image, gargs)
+		}(i, decoded_image)
+	}
+	resize_wg.Wait()
+	return resized_images
+}
+
+func fadeSprites(gargs GontageArgs, all_decoded_images []image.Image) []image.Image {
+	faded_images := make([]image.Image, len(all_decoded_images))
+	var fade_wg sync.WaitGroup
+	for i, decoded_image := range all_decoded_images {
+		fade_wg.Add(1)
+		go func(i int, decoded_image image.Image) {
+			defer fade_wg.Done()
+			faded_images[i] = applyFading(decoded_image, gargs)
+		}(i, decoded_image)
+	}
+	fade_wg.Wait()
+	return faded_images
+}
+
 func sliceChunk[T any](slice []T, chunkSize int) [][]T {
 	var chunks [][]T
 	for i := 0; i < len(slice); i += chunkSize {
@@ -223,50 +298,37 @@ func calcSheetDimensions(hframes int, all_decoded_images []image.Image) (int, in
 }
 
 func spritesToResizedSprites(gargs GontageArgs, all_decoded_images []image.Image, all_decoded_images_names []string, start time.Time) {
-	sprite_source_folder_resized_name := fmt.Sprintf("%v_resized_%vpx", gargs.Sprite_source_folder, gargs.Sprite_resize_px_resize)
+	sprite_source_folder_resized_name := fmt.Sprintf("%v_resized_%v", gargs.Sprite_source_folder, resizeSuffix(gargs))
 	os.Mkdir(sprite_source_folder_resized_name, 0755)
-	encoder_png := png.Encoder{CompressionLevel: png.BestSpeed}
-	encoder_jpg := jpeg.Options{Quality: 100}
-	// jpeg.Decode(r io.Reader)
 	for i, decoded_image := range all_decoded_images {
-		sprite_name := strings.Split(all_decoded_images_names[i], ".")
+		source_ext := filepath.Ext(all_decoded_images_names[i])
+		sprite_name := strings.TrimSuffix(all_decoded_images_names[i], source_ext)
 
 		// Apply resize first
-		resized_image := resize.Resize(uint(gargs.Sprite_resize_px_resize), uint(gargs.Sprite_resize_px_resize), decoded_image, resize.Lanczos3)
+		resized_image := resizeSprite(decoded_image, gargs)
 
-		// Apply fading if specified
-		if gargs.Fade_amount > 0 {
-			resized_image = applyFading(resized_image, gargs.Fade_amount, gargs.Fade_mode)
+		// Then the outline, so its width is in output pixels
+		if hasEffects(gargs) {
+			resized_image = applyEffects(resized_image, gargs)
 		}
 
-		// Determine output format - force PNG for faded JPG images
-		output_as_png := gargs.Fade_amount > 0 && (sprite_name[1] == "jpg" || sprite_name[1] == "jpeg" || sprite_name[1] == "jfif" || sprite_name[1] == "pjpeg" || sprite_name[1] == "pjp")
-
-		var resized_sprite_name string
-		if output_as_png {
-			resized_sprite_name = fmt.Sprintf("/%v.png", sprite_name[0])
-		} else {
-			switch sprite_name[1] {
-			case "jpg", "jpeg", "jfif", "pjpeg", "pjp":
-				resized_sprite_name = fmt.Sprintf("/%v.%v", sprite_name[0], sprite_name[1])
-			default:
-				resized_sprite_name = fmt.Sprintf("/%v.png", sprite_name[0])
-			}
+		// Apply fading if specified
+		if isFading(gargs) {
+			resized_image = applyFading(resized_image, gargs)
 		}
 
+		// Determine output format - faded JPG images are forced to PNG unless -of says otherwise
+		output_format := outputFormatFor(gargs, source_ext)
+		resized_sprite_name := fmt.Sprintf("/%v%v", expandName(gargs.Name_template, defaultResizedName, nameFields{name: sprite_name, index: i}), outputExtension(output_format, source_ext))
+
 		// Create the output file
 		f, err := os.Create(sprite_source_folder_resized_name + resized_sprite_name)
 		if err != nil {
 			panic(err)
 		}
 
-		// Encode based on output format
-		if output_as_png || sprite_name[1] == "png" {
-			if err = encoder_png.Encode(f, resized_image); err != nil {
-				log.Printf("failed to encode: %v", err)
-			}
-		} else {
-			jpeg.Encode(f, resized_image, &encoder_jpg)
+		if err = encodeImage(f, resized_image, output_format, gargs); err != nil {
+			log.Printf("failed to encode: %v", err)
 		}
 
 		fmt.Println(sprite_source_folder_resized_name + resized_sprite_name)
@@ -276,51 +338,84 @@ func spritesToResizedSprites(gargs GontageArgs, all_decoded_images []image.Image
 }
 
 func cutSpritesheetIntoSprites(gargs GontageArgs, all_decoded_images []image.Image, all_decoded_images_names []string, start time.Time) {
-	image_size := strings.Split(gargs.Cut_spritesheet, "x")
-	image_size_x, err := strconv.Atoi(image_size[0])
-	image_size_y, err := strconv.Atoi(image_size[1])
-	if err != nil {
-		log.Fatalln(err)
-	}
+	output_format := sheetOutputFormat(gargs)
 	var cut_spritesheet_wg sync.WaitGroup
 	for i, decoded_image := range all_decoded_images {
 		if decoded_image == nil {
 			continue
 		}
+		cut_rects, err := cutRects(gargs, decoded_image, all_decoded_images_names[i])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		background_key, err := backgroundKey(gargs)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// Without -bg, cells that are only the sheet's solid backdrop colour count as empty too.
+		empty_key := background_key
+		if empty_key == nil {
+			empty_key = sheetBackground(decoded_image)
+		}
 		cut_spritesheet_wg.Add(1)
-		var hframes = decoded_image.Bounds().Dx() / image_size_x
-		var vframes = decoded_image.Bounds().Dy() / image_size_y
-		frame_count := 0
 		go func() {
 			defer cut_spritesheet_wg.Done()
-			for v := range vframes {
-				for h := range hframes {
-					cutted_image := image.NewNRGBA(image.Rect(h*image_size_x, v*image_size_y, (h*image_size_x)+image_size_x, (v*image_size_y)+image_size_y))
-					r := image.Rect(h*image_size_x, v*image_size_y, (h*image_size_x)+image_size_x, (v*image_size_y)+image_size_y)
-					draw.Draw(cutted_image, r, decoded_image, image.Point{h * image_size_x, v * image_size_y}, draw.Over)
-
-					// Apply fading if specified
-					if gargs.Fade_amount > 0 {
-						faded := applyFading(cutted_image, gargs.Fade_amount, gargs.Fade_mode)
-						// Convert RGBA to NRGBA
-						bounds := faded.Bounds()
-						nrgbaImg := image.NewNRGBA(bounds)
-						draw.Draw(nrgbaImg, bounds, faded, bounds.Min, draw.Src)
-						cutted_image = nrgbaImg
-					}
-					folder_name := strings.Split(all_decoded_images_names[i], ".")
-					cut_sprite_name := filepath.Join(fmt.Sprintf("%v.png", frame_count))
-					os.Mkdir(filepath.Join(gargs.Sprite_source_folder, folder_name[0]), 0755)
-					sprite_output := filepath.Join(gargs.Sprite_source_folder, folder_name[0], cut_sprite_name)
-					f, err := os.Create(sprite_output)
-					if err != nil {
-						panic(err)
-					}
-					encoder := png.Encoder{CompressionLevel: png.BestSpeed}
-					if err = encoder.Encode(f, cutted_image); err != nil {
-						log.Printf("failed to encode: %v", err)
-					}
-					frame_count += 1
+			frame_count := 0
+			folder_name := strings.Split(all_decoded_images_names[i], ".")
+			metadata := sheetMetadata{
+				Image:  all_decoded_images_names[i],
+				Width:  decoded_image.Bounds().Dx(),
+				Height: decoded_image.Bounds().Dy(),
+				Scale:  1,
+			}
+			cell_positions := cellPositions(cut_rects)
+			for cell, r := range cut_rects {
+				cutted_image := image.NewNRGBA(r)
+				drawNRGBA(cutted_image, r, decoded_image, r.Min)
+				if gargs.Skip_empty && isEmptyCell(cutted_image, empty_key) {
+					// Skipped cells don't use up a frame number so the output stays contiguous.
+					continue
+				}
+				clearBackground(cutted_image, background_key)
+				if hasEffects(gargs) {
+					cutted_image = applyEffects(cutted_image, gargs)
+				}
+
+				// Apply fading if specified
+				if isFading(gargs) {
+					cutted_image = applyFading(cutted_image, gargs)
+				}
+				cut_sprite_name := fmt.Sprintf("%v%v", expandName(gargs.Name_template, defaultCutName, nameFields{
+					name:  folder_name[0],
+					index: frame_count,
+					row:   cell_positions[cell].Y,
+					col:   cell_positions[cell].X,
+				}), outputExtension(output_format, ""))
+				os.Mkdir(filepath.Join(gargs.Sprite_source_folder, folder_name[0]), 0755)
+				sprite_output := filepath.Join(gargs.Sprite_source_folder, folder_name[0], cut_sprite_name)
+				f, err := os.Create(sprite_output)
+				if err != nil {
+					panic(err)
+				}
+				if err = encodeImage(f, cutted_image, output_format, gargs); err != nil {
+					log.Printf("failed to encode: %v", err)
+				}
+				f.Close()
+				source_rect := r.Sub(decoded_image.Bounds().Min)
+				metadata.Frames = append(metadata.Frames, frameMetadata{
+					Name: cut_sprite_name,
+					X:    source_rect.Min.X,
+					Y:    source_rect.Min.Y,
+					W:    source_rect.Dx(),
+					H:    source_rect.Dy(),
+				})
+				frame_count += 1
+			}
+			// Detected rects can't be worked out again from the sheet alone, so always record them.
+			if gargs.Write_metadata || strings.ToLower(gargs.Cut_spritesheet) == cutDetect {
+				metadata_output := filepath.Join(gargs.Sprite_source_folder, folder_name[0], folder_name[0]+".json")
+				if err := writeMetadata(metadata_output, metadata); err != nil {
+					log.Printf("failed to write metadata: %v", err)
 				}
 			}
 		}()
@@ -330,9 +425,35 @@ func cutSpritesheetIntoSprites(gargs GontageArgs, all_decoded_images []image.Ima
 }
 
 func spritesToSpritesheet(gargs GontageArgs, all_decoded_images []image.Image, all_decoded_images_names []string, start time.Time) {
+	if isResizing(gargs) {
+		// Resize each sprite rather than the assembled sheet so -rm applies per cell and neighbours don't bleed.
+		all_decoded_images = resizeSprites(gargs, all_decoded_images)
+	}
+	if hasEffects(gargs) {
+		// Outline each sprite before packing, the sheet grows with the sprites.
+		all_decoded_images = effectSprites(gargs, all_decoded_images)
+	}
+	if isFading(gargs) {
+		// Fade after resizing, like -ss and -i, so the fade shape matches the final sprite.
+		all_decoded_images = fadeSprites(gargs, all_decoded_images)
+	}
+	if len(gargs.Scales) == 0 {
+		writeSpritesheet(gargs, all_decoded_images, all_decoded_images_names, 1, "", start)
+		return
+	}
+	// Every variant reuses the already decoded (and -sr resized) sprites.
+	for _, scale := range gargs.Scales {
+		scaled_images := all_decoded_images
+		if scale != 1 {
+			scaled_images = scaleSprites(gargs, all_decoded_images, scale)
+		}
+		writeSpritesheet(gargs, scaled_images, all_decoded_images_names, scale, scaleSuffix(scale), start)
+	}
+}
+
+func writeSpritesheet(gargs GontageArgs, all_decoded_images []image.Image, all_decoded_images_names []string, scale float64, name_suffix string, start time.Time) {
 	spritesheet_width, spritesheet_height, vframes := calcSheetDimensions(gargs.Hframes, all_decoded_images)
 	spritesheet := image.NewNRGBA(image.Rect(0, 0, spritesheet_width, spritesheet_height))
-	draw.Draw(spritesheet, spritesheet.Bounds(), spritesheet, image.Point{}, draw.Src)
 	decoded_images_to_draw_chunked := sliceChunk(all_decoded_images, gargs.Hframes)
 	var make_spritesheet_wg sync.WaitGroup
 	for count_vertical_frames, sprite_chunk := range decoded_images_to_draw_chunked {
@@ -352,133 +473,52 @@ func spritesToSpritesheet(gargs GontageArgs, all_decoded_images []image.Image, a
 	make_spritesheet_wg.Wait()
 
 	// Note: Fading is applied to individual sprites before assembly, not to the spritesheet itself
-	spritesheet_name := fmt.Sprintf("%v_f%v_v%v.png", gargs.Sprite_source_folder, len(all_decoded_images), vframes)
+	output_format := sheetOutputFormat(gargs)
+	spritesheet_name := fmt.Sprintf("%v_f%v_v%v%v%v", gargs.Sprite_source_folder, len(all_decoded_images), vframes, name_suffix, outputExtension(output_format, ""))
 	f, err := os.Create(spritesheet_name)
 	if err != nil {
 		panic(err)
 	}
-	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
-	if gargs.Sprite_resize_px_resize != 0 {
-		resized_spritesheet := resize.Resize(uint(gargs.Hframes*gargs.Sprite_resize_px_resize), uint(int(vframes)*gargs.Sprite_resize_px_resize),
-			spritesheet, resize.Lanczos3)
-		if err = encoder.Encode(f, resized_spritesheet); err != nil {
-			log.Printf("failed to encode: %v", err)
-		}
-	} else {
-		if err = encoder.Encode(f, spritesheet); err != nil {
-			log.Printf("failed to encode: %v", err)
-		}
+	if err = encodeImage(f, spritesheet, output_format, gargs); err != nil {
+		log.Printf("failed to encode: %v", err)
 	}
-
 	f.Close()
+
+	if gargs.Write_metadata {
+		metadata := spritesheetMetadata(filepath.Base(spritesheet_name), spritesheet, all_decoded_images, all_decoded_images_names, gargs.Hframes, int(vframes), scale)
+		if err = writeMetadata(metadataPath(spritesheet_name), metadata); err != nil {
+			log.Printf("failed to write metadata: %v", err)
+		}
+	}
 	fmt.Println(spritesheet_name, ": ", time.Since(start))
 }
 
-func applyFading(img image.Image, fadeAmount int, fadeMode string) *image.RGBA {
-	if fadeAmount <= 0 || fadeAmount > 100 {
-		// Convert to RGBA to maintain consistent return type
-		bounds := img.Bounds()
-		rgbaImg := image.NewRGBA(bounds)
-		draw.Draw(rgbaImg, bounds, img, bounds.Min, draw.Src)
-		return rgbaImg
+// applyFading fades img's alpha towards the -fm shape. It works on straight (non-premultiplied) alpha and
+// only changes A, so partially faded pixels keep the sprite's own colour.
+func applyFading(img image.Image, gargs GontageArgs) *image.NRGBA {
+	src := toNRGBA(img)
+	bounds := src.Bounds()
+	fadedImg := image.NewNRGBA(bounds)
+	drawNRGBA(fadedImg, bounds, src, bounds.Min)
+	if !isFading(gargs) {
+		return fadedImg
 	}
 
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
-
-	// Create new RGBA image to handle transparency properly
-	fadedImg := image.NewRGBA(bounds)
-
 	// Calculate dimensions for fading
-	centerX := float64(width) / 2.0
-	centerY := float64(height) / 2.0
-
-	var fadeDistanceX, fadeDistanceY float64
-
-	if fadeMode == "s" {
-		// Square fading
-		fadeDistanceX = centerX * (float64(fadeAmount) / 100.0)
-		fadeDistanceY = centerY * (float64(fadeAmount) / 100.0)
-	} else {
-		// Circle fading (default)
-		maxRadius := math.Min(centerX, centerY)
-		fadeRadius := maxRadius * (float64(fadeAmount) / 100.0)
-		fadeDistanceX = fadeRadius
-		fadeDistanceY = fadeRadius
-	}
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			// Get original pixel - use direct RGBA conversion to avoid color space issues
-			origColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
-
-			var alphaMultiplier float64 = 1.0
-
-			if fadeMode == "s" {
-				// Square fading
-				dx := math.Abs(float64(x) - centerX)
-				dy := math.Abs(float64(y) - centerY)
-
-				// Calculate fade progress based on both dimensions
-				var fadeProgress float64
-
-				if dx > centerX-fadeDistanceX && dy > centerY-fadeDistanceY {
-					// Corner region - use combined distance
-					xProgress := (dx - (centerX - fadeDistanceX)) / fadeDistanceX
-					yProgress := (dy - (centerY - fadeDistanceY)) / fadeDistanceY
-					fadeProgress = math.Max(xProgress, yProgress)
-				} else if dx > centerX-fadeDistanceX {
-					// X edge region
-					fadeProgress = (dx - (centerX - fadeDistanceX)) / fadeDistanceX
-				} else if dy > centerY-fadeDistanceY {
-					// Y edge region
-					fadeProgress = (dy - (centerY - fadeDistanceY)) / fadeDistanceY
-				} else {
-					// Inside the non-faded area
-					fadeProgress = 0
-				}
-
-				if fadeProgress >= 1.0 {
-					alphaMultiplier = 0.0 // Fully transparent
-				} else if fadeProgress <= 0 {
-					alphaMultiplier = 1.0 // Fully opaque
-				} else {
-					alphaMultiplier = 1.0 - fadeProgress
-				}
-			} else {
-				// Circle fading (default)
-				dx := float64(x) - centerX
-				dy := float64(y) - centerY
-				distance := math.Sqrt(dx*dx + dy*dy)
-				maxRadius := math.Min(centerX, centerY)
-
-				if distance <= maxRadius-fadeDistanceX {
-					// Inside the non-faded area - full opacity
-					alphaMultiplier = 1.0
-				} else if distance >= maxRadius {
-					// Outside the circle - fully transparent
-					alphaMultiplier = 0.0
-				} else {
-					// In the fade zone - gradient
-					fadeProgress := (distance - (maxRadius - fadeDistanceX)) / fadeDistanceX
-					alphaMultiplier = 1.0 - fadeProgress
+	shape := newFadeShape(bounds, gargs)
+
+	parallelRows(bounds.Dy(), func(y_start int, y_end int) {
+		for y := y_start; y < y_end; y++ {
+			row := fadedImg.Pix[fadedImg.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:bounds.Dx()*4]
+			for x := 0; x < bounds.Dx(); x++ {
+				if row[x*4+3] == 0 {
+					continue
 				}
+				alphaMultiplier := shape.alpha(float64(x), float64(y))
+				row[x*4+3] = uint8(math.Round(float64(row[x*4+3]) * alphaMultiplier))
 			}
-
-			// Apply alpha multiplier to original alpha and adjust RGB values to avoid artifacts
-			newAlpha := uint8(float64(origColor.A) * alphaMultiplier)
-			newR := uint8(float64(origColor.R) * alphaMultiplier)
-			newG := uint8(float64(origColor.G) * alphaMultiplier)
-			newB := uint8(float64(origColor.B) * alphaMultiplier)
-			fadedImg.Set(x, y, color.RGBA{
-				R: newR,
-				G: newG,
-				B: newB,
-				A: newAlpha,
-			})
 		}
-	}
+	})
 
 	return fadedImg
 }
@@ -486,10 +526,14 @@ func applyFading(img image.Image, fadeAmount int, fadeMode string) *image.RGBA {
 func ResizeSingleImage(gargs GontageArgs) {
 	start := time.Now()
 
-	if gargs.Sprite_resize_px_resize == 0 {
+	if !isResizing(gargs) {
 		fmt.Println("Error: -sr flag is required when using -i flag to specify resize dimensions")
 		os.Exit(1)
 	}
+	if err := validateArgs(gargs, ValidateOutputOptions, validateResizeOptions, validateFadeOptions, validateEffectOptions); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
 	// Check if file exists
 	if _, err := os.Stat(gargs.Image_path); os.IsNotExist(err) {
@@ -539,28 +583,23 @@ func ResizeSingleImage(gargs GontageArgs) {
 	}
 
 	// Resize the image
-	resized_image := resize.Resize(uint(gargs.Sprite_resize_px_resize), uint(gargs.Sprite_resize_px_resize), decoded_image, resize.Lanczos3)
+	resized_image := resizeSprite(decoded_image, gargs)
+
+	// Then the outline, so its width is in output pixels
+	if hasEffects(gargs) {
+		resized_image = applyEffects(resized_image, gargs)
+	}
 
 	// Apply fading if specified
-	if gargs.Fade_amount > 0 {
-		resized_image = applyFading(resized_image, gargs.Fade_amount, gargs.Fade_mode)
+	if isFading(gargs) {
+		resized_image = applyFading(resized_image, gargs)
 	}
 
-	// Generate output filename
+	// Generate output filename - faded JPG images are forced to PNG unless -of says otherwise
 	file_ext := filepath.Ext(gargs.Image_path)
 	file_name_without_ext := strings.TrimSuffix(filepath.Base(gargs.Image_path), file_ext)
-
-	var output_filename string
-	var should_output_png bool
-
-	// Check if we need to force PNG output (faded JPG images)
-	if gargs.Fade_amount > 0 && (strings.ToLower(file_ext) == ".jpg" || strings.ToLower(file_ext) == ".jpeg" || strings.ToLower(file_ext) == ".jfif" || strings.ToLower(file_ext) == ".pjpeg" || strings.ToLower(file_ext) == ".pjp") {
-		output_filename = fmt.Sprintf("%s_resized_%dpx.png", file_name_without_ext, gargs.Sprite_resize_px_resize)
-		should_output_png = true
-	} else {
-		output_filename = fmt.Sprintf("%s_resized_%dpx%s", file_name_without_ext, gargs.Sprite_resize_px_resize, file_ext)
-		should_output_png = false
-	}
+	output_format := outputFormatFor(gargs, file_ext)
+	output_filename := fmt.Sprintf("%s_resized_%s%s", file_name_without_ext, resizeSuffix(gargs), outputExtension(output_format, file_ext))
 
 	// Create output file
 	output_file, err := os.Create(output_filename)
@@ -570,20 +609,7 @@ func ResizeSingleImage(gargs GontageArgs) {
 	defer output_file.Close()
 
 	// Encode and save the resized image
-	if should_output_png {
-		encoder_png := png.Encoder{CompressionLevel: png.BestSpeed}
-		err = encoder_png.Encode(output_file, resized_image)
-	} else {
-		switch strings.ToLower(file_ext) {
-		case ".jpg", ".jpeg", ".jfif", ".pjpeg", ".pjp":
-			encoder_jpg := jpeg.Options{Quality: 100}
-			err = jpeg.Encode(output_file, resized_image, &encoder_jpg)
-		default:
-			encoder_png := png.Encoder{CompressionLevel: png.BestSpeed}
-			err = encoder_png.Encode(output_file, resized_image)
-		}
-	}
-
+	err = encodeImage(output_file, resized_image, output_format, gargs)
 	if err != nil {
 		log.Fatalf("Error encoding resized image: %v", err)
 	}
@@ -645,6 +671,26 @@ func tryDecodePngWithFix(imagePath string) (image.Image, error) {
 	return tryRecreateValidPng(imagePath)
 }
 
+// decodeImageFile decodes a single TGA or standard image file, fixing PNG checksums when -fix-png is set.
+func decodeImageFile(path string, fix_png_checksum bool) (image.Image, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".tga" {
+		reader, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return tga.Decode(reader)
+	}
+	decoded, err := tryStandardDecode(path)
+	if err != nil && fix_png_checksum && strings.ToLower(filepath.Ext(path)) == ".png" {
+		if fix_err := FixPngChecksum(path); fix_err != nil {
+			return nil, fmt.Errorf("%v (fixing the PNG checksum failed: %v)", err, fix_err)
+		}
+		return tryStandardDecode(path)
+	}
+	return decoded, err
+}
+
 // tryStandardDecode attempts normal PNG decoding
 func tryStandardDecode(imagePath string) (image.Image, error) {
 	reader, err := os.Open(imagePath)
@@ -750,7 +796,7 @@ func createValidPngFromData(data []byte, outputPath string) error {
 }
 
 // writeChunk writes a PNG chunk with correct CRC
-func writeChunk(w *os.File, chunkType []byte, data []byte) {
+func writeChunk(w io.Writer, chunkType []byte, data []byte) {
 	// Write length
 	length := uint32(len(data))
 	w.Write([]byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)})
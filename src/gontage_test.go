@@ -0,0 +1,22 @@
+package gontage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateArgsReturnsFirstError(t *testing.T) {
+	// Both the output and the resize options are wrong, the output check runs first.
+	gargs := GontageArgs{Jpeg_quality: 0, Resize_mode: "squash"}
+	err := validateArgs(gargs, ValidateOutputOptions, validateResizeOptions)
+	if err == nil || !strings.Contains(err.Error(), "jpeg quality") {
+		t.Errorf("error %v, want the jpeg quality error", err)
+	}
+	err = validateArgs(gargs, validateResizeOptions, ValidateOutputOptions)
+	if err == nil || !strings.Contains(err.Error(), "resize mode") {
+		t.Errorf("error %v, want the resize mode error", err)
+	}
+	if err := validateArgs(GontageArgs{Jpeg_quality: 100}, ValidateOutputOptions, validateResizeOptions); err != nil {
+		t.Errorf("valid options: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package gontage
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sheetMetadata is written next to a spritesheet as <spritesheet name>.json when -meta is set.
+type sheetMetadata struct {
+	Image   string          `json:"image"`
+	Width   int             `json:"width"`
+	Height  int             `json:"height"`
+	Scale   float64         `json:"scale"`
+	Hframes int             `json:"hframes"`
+	Vframes int             `json:"vframes"`
+	Frames  []frameMetadata `json:"frames"`
+}
+
+type frameMetadata struct {
+	Name string `json:"name"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	W    int    `json:"w"`
+	H    int    `json:"h"`
+}
+
+// metadataPath is where the metadata for the image at image_path lives.
+func metadataPath(image_path string) string {
+	return strings.TrimSuffix(image_path, filepath.Ext(image_path)) + ".json"
+}
+
+// spritesheetMetadata lists frame rects in the same layout drawSpritesheet uses.
+func spritesheetMetadata(image_name string, spritesheet image.Image, sprites []image.Image, names []string, hframes int, vframes int, scale float64) sheetMetadata {
+	metadata := sheetMetadata{
+		Image:   image_name,
+		Width:   spritesheet.Bounds().Dx(),
+		Height:  spritesheet.Bounds().Dy(),
+		Scale:   scale,
+		Hframes: hframes,
+		Vframes: vframes,
+	}
+	for i, sprite := range sprites {
+		width, height := sprite.Bounds().Dx(), sprite.Bounds().Dy()
+		metadata.Frames = append(metadata.Frames, frameMetadata{
+			Name: names[i],
+			X:    (i % hframes) * width,
+			Y:    (i / hframes) * height,
+			W:    width,
+			H:    height,
+		})
+	}
+	return metadata
+}
+
+func writeMetadata(path string, metadata any) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,105 @@
+package gontage
+
+import (
+	"fmt"
+	"image"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default -name templates, matching the names written before templates existed.
+const (
+	defaultCutName     = "{index}"
+	defaultResizedName = "{name}"
+)
+
+// namePlaceholder matches {field} and {field:width}, e.g. {index:04} zero pads the index to 4 digits.
+var namePlaceholder = regexp.MustCompile(`\{([a-z]+)(?::(\d+))?\}`)
+
+// nameFields are the values a -name template can use.
+// name is the source sheet or sprite name without its extension, index counts the written outputs from 0,
+// row and col are the grid position of a cut cell.
+type nameFields struct {
+	name  string
+	index int
+	row   int
+	col   int
+}
+
+func validateNameTemplate(gargs GontageArgs) error {
+	template := gargs.Name_template
+	if template == "" {
+		return nil
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("name template %q can't contain path separators", template)
+	}
+	used := map[string]bool{}
+	for _, match := range namePlaceholder.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case "name", "index", "row", "col":
+			used[match[1]] = true
+		default:
+			return fmt.Errorf("unknown placeholder {%s} in name template %q (expected {name}, {index}, {row} or {col})", match[1], template)
+		}
+	}
+	if remaining := namePlaceholder.ReplaceAllString(template, ""); strings.ContainsAny(remaining, "{}") {
+		return fmt.Errorf("invalid placeholder in name template %q (expected e.g. {name}_{index:04})", template)
+	}
+	// Every output needs its own name, otherwise frames overwrite each other.
+	if gargs.Single_sprites {
+		if !used["name"] && !used["index"] {
+			return fmt.Errorf("name template %q needs {name} or {index} when resizing sprites", template)
+		}
+	} else if gargs.Cut_spritesheet != "" || gargs.Cut_grid != "" {
+		if !used["index"] && !(used["row"] && used["col"]) && !(isAtlasFile(gargs.Cut_spritesheet) && used["name"]) {
+			return fmt.Errorf("name template %q needs {index} or {row} and {col} when cutting", template)
+		}
+	}
+	return nil
+}
+
+// expandName fills in a -name template, falling back to fallback when no template is set.
+func expandName(template string, fallback string, fields nameFields) string {
+	if template == "" {
+		template = fallback
+	}
+	return namePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		match := namePlaceholder.FindStringSubmatch(placeholder)
+		var value int
+		switch match[1] {
+		case "name":
+			return fields.name
+		case "index":
+			value = fields.index
+		case "row":
+			value = fields.row
+		case "col":
+			value = fields.col
+		}
+		if match[2] == "" {
+			return strconv.Itoa(value)
+		}
+		width, _ := strconv.Atoi(match[2])
+		return fmt.Sprintf("%0*d", width, value)
+	})
+}
+
+// cellPositions gives the column and row of each cut rect. Rects come in reading order, so a new row
+// starts whenever a rect wraps back to the left, which numbers grid, auto and detected cuts alike.
+func cellPositions(rects []image.Rectangle) []image.Point {
+	positions := make([]image.Point, len(rects))
+	row, col := 0, 0
+	for i, r := range rects {
+		if i > 0 {
+			if r.Min.X <= rects[i-1].Min.X {
+				row, col = row+1, 0
+			} else {
+				col++
+			}
+		}
+		positions[i] = image.Pt(col, row)
+	}
+	return positions
+}
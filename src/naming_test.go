@@ -0,0 +1,101 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandName(t *testing.T) {
+	fields := nameFields{name: "walk", index: 7, row: 1, col: 12}
+	for _, tc := range []struct {
+		template string
+		want     string
+	}{
+		{"", "7"},
+		{"{index:04}", "0007"},
+		{"{name}_{index:3}", "walk_007"},
+		{"{name}_r{row}_c{col:02}", "walk_r1_c12"},
+		{"frame-{index}-{index:02}", "frame-7-07"},
+	} {
+		if got := expandName(tc.template, defaultCutName, fields); got != tc.want {
+			t.Errorf("expandName(%q) = %q, want %q", tc.template, got, tc.want)
+		}
+	}
+	if got := expandName("", defaultResizedName, fields); got != "walk" {
+		t.Errorf("default resized name = %q, want walk", got)
+	}
+}
+
+func TestValidateNameTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		gargs GontageArgs
+		ok    bool
+	}{
+		{GontageArgs{Name_template: "{name}_{index:04}", Cut_spritesheet: "16x16"}, true},
+		{GontageArgs{Name_template: "{row}_{col}", Cut_spritesheet: "16x16"}, true},
+		{GontageArgs{Name_template: "{name}", Cut_spritesheet: "atlas.json"}, true},
+		{GontageArgs{Name_template: "{name}", Single_sprites: true}, true},
+		{GontageArgs{Name_template: "{frame}", Cut_spritesheet: "16x16"}, false},
+		{GontageArgs{Name_template: "{Index}", Cut_spritesheet: "16x16"}, false},
+		{GontageArgs{Name_template: "{index", Cut_spritesheet: "16x16"}, false},
+		{GontageArgs{Name_template: "{index:x}", Cut_spritesheet: "16x16"}, false},
+		{GontageArgs{Name_template: "sub/{index}", Cut_spritesheet: "16x16"}, false},
+		// Outputs would overwrite each other.
+		{GontageArgs{Name_template: "{row}", Cut_spritesheet: "16x16"}, false},
+		{GontageArgs{Name_template: "{name}", Cut_spritesheet: "16x16"}, false},
+		{GontageArgs{Name_template: "{row}_{col}", Single_sprites: true}, false},
+	} {
+		err := validateNameTemplate(tc.gargs)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: error %v, want ok %v", tc.gargs.Name_template, err, tc.ok)
+		}
+	}
+}
+
+func TestCellPositions(t *testing.T) {
+	// Detected sprites of different sizes still number by the row they wrap onto.
+	rects := []image.Rectangle{
+		image.Rect(0, 0, 10, 10), image.Rect(12, 2, 20, 8), image.Rect(30, 0, 40, 10),
+		image.Rect(0, 12, 10, 20), image.Rect(15, 14, 18, 16),
+	}
+	want := []image.Point{{0, 0}, {1, 0}, {2, 0}, {0, 1}, {1, 1}}
+	if got := cellPositions(rects); !slices.Equal(got, want) {
+		t.Errorf("positions %v, want %v", got, want)
+	}
+}
+
+func TestCutNamesWithSkippedCells(t *testing.T) {
+	inTempDir(t, func() {
+		// A 3x2 sheet of 16px cells where the second and fourth cells are empty.
+		sheet := image.NewNRGBA(image.Rect(0, 0, 48, 32))
+		for _, cell := range []image.Point{{0, 0}, {2, 0}, {1, 1}, {2, 1}} {
+			sheet.SetNRGBA(cell.X*16+8, cell.Y*16+8, color.NRGBA{255, 0, 0, 255})
+		}
+		os.Mkdir("sheets", 0755)
+		writeTestPng(t, filepath.Join("sheets", "sheet.png"), sheet)
+		Gontage(GontageArgs{
+			Sprite_source_folder: "sheets",
+			Cut_spritesheet:      "16x16",
+			Skip_empty:           true,
+			Name_template:        "{row}_{col}_{index:04}",
+			Jpeg_quality:         100,
+		})
+		entries, err := os.ReadDir(filepath.Join("sheets", "sheet"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		// {row} and {col} keep the grid position of each cell, {index} stays contiguous.
+		want := []string{"0_0_0000.png", "0_2_0001.png", "1_1_0002.png", "1_2_0003.png"}
+		if !slices.Equal(names, want) {
+			t.Errorf("cut %v, want %v", names, want)
+		}
+	})
+}
@@ -0,0 +1,382 @@
+package gontage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sort"
+	"sync"
+)
+
+// PNG colour types (PNG spec section 11.2.2).
+const (
+	pngColorGray      = 0
+	pngColorRGB       = 2
+	pngColorPalette   = 3
+	pngColorGrayAlpha = 4
+	pngColorRGBA      = 6
+)
+
+// Scanline filter strategies tried by the optimizer, pngFilterAdaptive picks the best filter per row.
+const (
+	pngFilterNone = iota
+	pngFilterSub
+	pngFilterUp
+	pngFilterAverage
+	pngFilterPaeth
+	pngFilterAdaptive
+)
+
+// pngCandidate is one lossless way of representing an image as PNG samples.
+type pngCandidate struct {
+	color_type uint8
+	bit_depth  uint8
+	plte       []byte
+	trns       []byte
+	rows       [][]byte
+	// bytes per complete pixel, rounded up to 1 for sub-byte depths (used by the filters).
+	bpp int
+}
+
+// encodeOptimizedPng losslessly writes the smallest PNG it can find for img.
+// It reduces colour type and bit depth where the pixels allow, tries every filter strategy at
+// several zlib levels and only writes the critical chunks (IHDR, PLTE, tRNS, IDAT, IEND).
+func encodeOptimizedPng(w io.Writer, img image.Image) error {
+	if img.Bounds().Empty() || !fitsIn8Bits(img) {
+		// 16-bit samples can't be reduced losslessly, leave them to the standard encoder.
+		// So are empty images, which have no valid IHDR.
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		return encoder.Encode(w, img)
+	}
+	nrgba := toNRGBA(img)
+	candidates := pngCandidates(nrgba)
+
+	type attempt struct {
+		candidate *pngCandidate
+		filter    int
+		level     int
+	}
+	var attempts []attempt
+	for _, candidate := range candidates {
+		for filter := pngFilterNone; filter <= pngFilterAdaptive; filter++ {
+			for _, level := range []int{zlib.BestCompression, zlib.DefaultCompression} {
+				attempts = append(attempts, attempt{candidate, filter, level})
+			}
+		}
+	}
+
+	results := make([][]byte, len(attempts))
+	var optimize_wg sync.WaitGroup
+	for i, a := range attempts {
+		optimize_wg.Add(1)
+		go func(i int, a attempt) {
+			defer optimize_wg.Done()
+			results[i] = compressCandidate(a.candidate, a.filter, a.level)
+		}(i, a)
+	}
+	optimize_wg.Wait()
+
+	best := 0
+	for i := range results {
+		if len(results[i]) < len(results[best]) {
+			best = i
+		}
+	}
+	return writeOptimizedPng(w, nrgba.Bounds(), attempts[best].candidate, results[best])
+}
+
+// fitsIn8Bits reports whether every sample of img survives a round trip through 8 bits.
+func fitsIn8Bits(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA, *image.RGBA, *image.Paletted, *image.Gray, *image.YCbCr, *image.CMYK:
+		return true
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			for _, v := range []uint16{c.R, c.G, c.B, c.A} {
+				if v != (v>>8)*0x101 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// pngCandidates lists every colour type/bit depth that can hold img without loss.
+func pngCandidates(img *image.NRGBA) []*pngCandidate {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	opaque, gray := true, true
+	counts := map[color.NRGBA]int{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A != 255 {
+				opaque = false
+			}
+			if c.A == 0 {
+				// Colour under fully transparent pixels is invisible, normalise it so it packs better.
+				c = color.NRGBA{}
+			}
+			if c.R != c.G || c.G != c.B {
+				gray = false
+			}
+			if len(counts) <= 256 {
+				counts[c]++
+			}
+		}
+	}
+	pixel := func(x, y int) color.NRGBA {
+		c := img.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+		if c.A == 0 {
+			return color.NRGBA{}
+		}
+		return c
+	}
+
+	var candidates []*pngCandidate
+	if opaque {
+		rgb := &pngCandidate{color_type: pngColorRGB, bit_depth: 8, bpp: 3}
+		for y := 0; y < height; y++ {
+			row := make([]byte, width*3)
+			for x := 0; x < width; x++ {
+				c := pixel(x, y)
+				row[x*3], row[x*3+1], row[x*3+2] = c.R, c.G, c.B
+			}
+			rgb.rows = append(rgb.rows, row)
+		}
+		candidates = append(candidates, rgb)
+	} else {
+		rgba := &pngCandidate{color_type: pngColorRGBA, bit_depth: 8, bpp: 4}
+		for y := 0; y < height; y++ {
+			row := make([]byte, width*4)
+			for x := 0; x < width; x++ {
+				c := pixel(x, y)
+				row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = c.R, c.G, c.B, c.A
+			}
+			rgba.rows = append(rgba.rows, row)
+		}
+		candidates = append(candidates, rgba)
+	}
+
+	if gray && opaque {
+		depth := uint8(1)
+		for c := range counts {
+			depth = max(depth, grayDepth(c.R))
+		}
+		if len(counts) > 256 {
+			depth = 8
+		}
+		scale := 255 / (1<<depth - 1)
+		grayscale := &pngCandidate{color_type: pngColorGray, bit_depth: depth, bpp: 1}
+		for y := 0; y < height; y++ {
+			samples := make([]byte, width)
+			for x := 0; x < width; x++ {
+				samples[x] = pixel(x, y).R / uint8(scale)
+			}
+			grayscale.rows = append(grayscale.rows, packSamples(samples, depth))
+		}
+		candidates = append(candidates, grayscale)
+	} else if gray {
+		gray_alpha := &pngCandidate{color_type: pngColorGrayAlpha, bit_depth: 8, bpp: 2}
+		for y := 0; y < height; y++ {
+			row := make([]byte, width*2)
+			for x := 0; x < width; x++ {
+				c := pixel(x, y)
+				row[x*2], row[x*2+1] = c.R, c.A
+			}
+			gray_alpha.rows = append(gray_alpha.rows, row)
+		}
+		candidates = append(candidates, gray_alpha)
+	}
+
+	if len(counts) <= 256 {
+		// Translucent entries first keeps tRNS short, then most used colours first.
+		colors := make([]color.NRGBA, 0, len(counts))
+		for c := range counts {
+			colors = append(colors, c)
+		}
+		sort.Slice(colors, func(i, j int) bool {
+			if colors[i].A != colors[j].A {
+				return colors[i].A < colors[j].A
+			}
+			if counts[colors[i]] != counts[colors[j]] {
+				return counts[colors[i]] > counts[colors[j]]
+			}
+			return binary.BigEndian.Uint32([]byte{colors[i].R, colors[i].G, colors[i].B, colors[i].A}) <
+				binary.BigEndian.Uint32([]byte{colors[j].R, colors[j].G, colors[j].B, colors[j].A})
+		})
+		index := map[color.NRGBA]uint8{}
+		paletted := &pngCandidate{color_type: pngColorPalette, bpp: 1}
+		for i, c := range colors {
+			index[c] = uint8(i)
+			paletted.plte = append(paletted.plte, c.R, c.G, c.B)
+			if c.A != 255 {
+				paletted.trns = append(paletted.trns, c.A)
+			}
+		}
+		switch {
+		case len(colors) <= 2:
+			paletted.bit_depth = 1
+		case len(colors) <= 4:
+			paletted.bit_depth = 2
+		case len(colors) <= 16:
+			paletted.bit_depth = 4
+		default:
+			paletted.bit_depth = 8
+		}
+		for y := 0; y < height; y++ {
+			samples := make([]byte, width)
+			for x := 0; x < width; x++ {
+				samples[x] = index[pixel(x, y)]
+			}
+			paletted.rows = append(paletted.rows, packSamples(samples, paletted.bit_depth))
+		}
+		candidates = append(candidates, paletted)
+	}
+	return candidates
+}
+
+// grayDepth is the smallest bit depth that represents v exactly.
+func grayDepth(v uint8) uint8 {
+	switch {
+	case v%0xff == 0:
+		return 1
+	case v%0x55 == 0:
+		return 2
+	case v%0x11 == 0:
+		return 4
+	}
+	return 8
+}
+
+// packSamples packs one sample per byte into depth bit samples, most significant bits first.
+func packSamples(samples []byte, depth uint8) []byte {
+	if depth == 8 {
+		return samples
+	}
+	per_byte := 8 / int(depth)
+	packed := make([]byte, (len(samples)+per_byte-1)/per_byte)
+	for i, s := range samples {
+		shift := 8 - int(depth)*(i%per_byte+1)
+		packed[i/per_byte] |= s << shift
+	}
+	return packed
+}
+
+// compressCandidate filters and deflates the candidate's scanlines into IDAT data.
+func compressCandidate(candidate *pngCandidate, filter int, level int) []byte {
+	var buf bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&buf, level)
+	var previous []byte
+	filtered := make([][]byte, pngFilterAdaptive)
+	for _, row := range candidate.rows {
+		if previous == nil {
+			previous = make([]byte, len(row))
+		}
+		chosen := filter
+		if filter == pngFilterAdaptive {
+			// Minimum sum of absolute differences heuristic from the PNG spec.
+			best_sum := -1
+			for f := pngFilterNone; f < pngFilterAdaptive; f++ {
+				filtered[f] = filterRow(f, row, previous, candidate.bpp)
+				sum := 0
+				for _, b := range filtered[f] {
+					sum += int(absInt8(int8(b)))
+				}
+				if best_sum == -1 || sum < best_sum {
+					chosen, best_sum = f, sum
+				}
+			}
+			zw.Write([]byte{byte(chosen)})
+			zw.Write(filtered[chosen])
+		} else {
+			zw.Write([]byte{byte(chosen)})
+			zw.Write(filterRow(chosen, row, previous, candidate.bpp))
+		}
+		previous = row
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func absInt8(v int8) int {
+	if v < 0 {
+		return -int(v)
+	}
+	return int(v)
+}
+
+func filterRow(filter int, row []byte, previous []byte, bpp int) []byte {
+	out := make([]byte, len(row))
+	for i := range row {
+		var a, b, c byte
+		if i >= bpp {
+			a = row[i-bpp]
+			c = previous[i-bpp]
+		}
+		b = previous[i]
+		switch filter {
+		case pngFilterSub:
+			out[i] = row[i] - a
+		case pngFilterUp:
+			out[i] = row[i] - b
+		case pngFilterAverage:
+			out[i] = row[i] - byte((int(a)+int(b))/2)
+		case pngFilterPaeth:
+			out[i] = row[i] - paethPredictor(a, b, c)
+		default:
+			out[i] = row[i]
+		}
+	}
+	return out
+}
+
+func paethPredictor(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := p-int(a), p-int(b), p-int(c)
+	if pa < 0 {
+		pa = -pa
+	}
+	if pb < 0 {
+		pb = -pb
+	}
+	if pc < 0 {
+		pc = -pc
+	}
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func writeOptimizedPng(w io.Writer, bounds image.Rectangle, candidate *pngCandidate, idat []byte) error {
+	var out bytes.Buffer
+	out.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(bounds.Dy()))
+	ihdr[8] = candidate.bit_depth
+	ihdr[9] = candidate.color_type
+	writeChunk(&out, []byte("IHDR"), ihdr)
+	if candidate.plte != nil {
+		writeChunk(&out, []byte("PLTE"), candidate.plte)
+	}
+	if len(candidate.trns) > 0 {
+		writeChunk(&out, []byte("tRNS"), candidate.trns)
+	}
+	writeChunk(&out, []byte("IDAT"), idat)
+	writeChunk(&out, []byte("IEND"), nil)
+	_, err := w.Write(out.Bytes())
+	return err
+}
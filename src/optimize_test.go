@@ -0,0 +1,120 @@
+package gontage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// optimizeTestImages cover the colour types the optimizer reduces to.
+func optimizeTestImages() map[string]image.Image {
+	paletted := image.NewPaletted(image.Rect(0, 0, 9, 7), color.Palette{
+		color.NRGBA{0, 0, 0, 0}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 128, 255, 128}, color.NRGBA{20, 200, 20, 255}, color.NRGBA{9, 9, 9, 255},
+	})
+	two_grays := image.NewGray(image.Rect(0, 0, 13, 5))
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	gray_alpha := testImage(23, 17, func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(x * 11), uint8(x * 11), uint8(x * 11), uint8(y * 15)}
+	})
+	rgb := image.NewRGBA(image.Rect(0, 0, 29, 11))
+	rgba := testImage(31, 19, func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(x * 8), uint8(y * 13), uint8(x * y), uint8(255 - x*y%200)}
+	})
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i*7) % 5
+	}
+	for i := range two_grays.Pix {
+		two_grays.Pix[i] = uint8(i%3/2) * 255
+	}
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	for y := 0; y < 11; y++ {
+		for x := 0; x < 29; x++ {
+			rgb.SetRGBA(x, y, color.RGBA{uint8(x * 9), uint8(y * 23), uint8(x * y * 3), 255})
+		}
+	}
+	return map[string]image.Image{
+		"palette":    paletted,
+		"1-bit gray": two_grays,
+		"gray":       gray,
+		"gray alpha": gray_alpha,
+		"rgb":        rgb,
+		"rgba":       rgba,
+		// A sub image checks the bounds don't have to start at 0,0.
+		"rgba sub image": rgba.SubImage(image.Rect(5, 3, 30, 18)),
+	}
+}
+
+// checkSamePixels compares the premultiplied colours, the optimizer drops the invisible colour of fully
+// transparent pixels but everything else must match exactly.
+func checkSamePixels(t *testing.T, name string, got image.Image, want image.Image) {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("%s: size %v, want %v", name, got.Bounds().Size(), want.Bounds().Size())
+	}
+	got_min, want_min := got.Bounds().Min, want.Bounds().Min
+	for y := 0; y < want.Bounds().Dy(); y++ {
+		for x := 0; x < want.Bounds().Dx(); x++ {
+			g := color.RGBA64Model.Convert(got.At(got_min.X+x, got_min.Y+y))
+			w := color.RGBA64Model.Convert(want.At(want_min.X+x, want_min.Y+y))
+			if g != w {
+				t.Fatalf("%s: pixel %d,%d = %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestEncodeOptimizedPngIsLossless(t *testing.T) {
+	for name, img := range optimizeTestImages() {
+		var buf bytes.Buffer
+		if err := encodeOptimizedPng(&buf, img); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		decoded, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkSamePixels(t, name, decoded, img)
+	}
+}
+
+func TestEveryPngCandidateIsLossless(t *testing.T) {
+	// encodeOptimizedPng only writes the smallest candidate, check the others decode to the same pixels too.
+	color_types := map[uint8]bool{}
+	for name, img := range optimizeTestImages() {
+		nrgba := toNRGBA(img)
+		for _, candidate := range pngCandidates(nrgba) {
+			color_types[candidate.color_type] = true
+			for filter := pngFilterNone; filter <= pngFilterAdaptive; filter++ {
+				var buf bytes.Buffer
+				idat := compressCandidate(candidate, filter, 6)
+				if err := writeOptimizedPng(&buf, nrgba.Bounds(), candidate, idat); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				decoded, err := png.Decode(&buf)
+				if err != nil {
+					t.Fatalf("%s colour type %d depth %d filter %d: %v", name, candidate.color_type, candidate.bit_depth, filter, err)
+				}
+				checkSamePixels(t, name, decoded, img)
+			}
+		}
+	}
+	for _, color_type := range []uint8{pngColorGray, pngColorRGB, pngColorPalette, pngColorGrayAlpha, pngColorRGBA} {
+		if !color_types[color_type] {
+			t.Errorf("no test image produced colour type %d", color_type)
+		}
+	}
+}
+
+func TestEncodeOptimizedPngEmptyImage(t *testing.T) {
+	var optimized, standard bytes.Buffer
+	empty := image.NewNRGBA(image.Rectangle{})
+	err_optimized := encodeOptimizedPng(&optimized, empty)
+	err_standard := png.Encode(&standard, empty)
+	if (err_optimized == nil) != (err_standard == nil) || !bytes.Equal(optimized.Bytes(), standard.Bytes()) {
+		t.Errorf("empty image: got %v, want the standard encoder's result %v", err_optimized, err_standard)
+	}
+}
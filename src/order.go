@@ -0,0 +1,141 @@
+package gontage
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Frame orders accepted by GontageArgs.Frame_order (-order), anything else is read as an order list file.
+const (
+	orderNatural = "natural"
+	orderLexical = "lexical"
+	orderMtime   = "mtime"
+)
+
+// sortSpritesFolder puts the folder entries in frame order. os.ReadDir is lexical, so frame10.png
+// would otherwise land before frame2.png.
+func sortSpritesFolder(sprites_folder []fs.DirEntry, gargs GontageArgs) ([]fs.DirEntry, error) {
+	sorted := append([]fs.DirEntry(nil), sprites_folder...)
+	natural := func(i, j int) bool { return naturalLess(sorted[i].Name(), sorted[j].Name()) }
+	switch strings.ToLower(gargs.Frame_order) {
+	case "", orderNatural:
+		sort.SliceStable(sorted, natural)
+	case orderLexical:
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name() < sorted[j].Name() })
+	case orderMtime:
+		mod_times := map[string]int64{}
+		for _, entry := range sorted {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			mod_times[entry.Name()] = info.ModTime().UnixNano()
+		}
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if mod_times[sorted[i].Name()] != mod_times[sorted[j].Name()] {
+				return mod_times[sorted[i].Name()] < mod_times[sorted[j].Name()]
+			}
+			return natural(i, j)
+		})
+	default:
+		order, err := readOrderList(gargs)
+		if err != nil {
+			return nil, err
+		}
+		// Listed frames come first in list order, anything not listed follows in natural order.
+		sort.SliceStable(sorted, func(i, j int) bool {
+			position_i, listed_i := order[sorted[i].Name()]
+			position_j, listed_j := order[sorted[j].Name()]
+			switch {
+			case listed_i && listed_j:
+				return position_i < position_j
+			case listed_i != listed_j:
+				return listed_i
+			default:
+				return natural(i, j)
+			}
+		})
+	}
+	return sorted, nil
+}
+
+// readOrderList reads an order list file: one file name per line, blank lines and # comments ignored.
+// A relative path is looked up in the sprite folder first, so each -mf sub folder can carry its own list.
+func readOrderList(gargs GontageArgs) (map[string]int, error) {
+	path := gargs.Frame_order
+	if !filepath.IsAbs(path) {
+		in_folder := filepath.Join(gargs.Sprite_source_folder, path)
+		if _, err := os.Stat(in_folder); err == nil {
+			path = in_folder
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unknown frame order %q (expected natural, lexical, mtime or an order list file)", gargs.Frame_order)
+	}
+	defer f.Close()
+	order := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if _, ok := order[name]; !ok {
+			order[name] = len(order)
+		}
+	}
+	return order, scanner.Err()
+}
+
+// naturalLess compares names with runs of digits compared by value, so "frame2" < "frame10".
+// Letters compare case-insensitively, ties fall back to a plain comparison to stay deterministic.
+func naturalLess(a string, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			start_i, start_j := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			number_a := strings.TrimLeft(a[start_i:i], "0")
+			number_b := strings.TrimLeft(b[start_j:j], "0")
+			if len(number_a) != len(number_b) {
+				return len(number_a) < len(number_b)
+			}
+			if number_a != number_b {
+				return number_a < number_b
+			}
+			continue
+		}
+		char_a, char_b := lowerAscii(a[i]), lowerAscii(b[j])
+		if char_a != char_b {
+			return char_a < char_b
+		}
+		i++
+		j++
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func lowerAscii(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
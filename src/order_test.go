@@ -0,0 +1,88 @@
+package gontage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNaturalLess(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"frame2.png", "frame10.png", true},
+		{"frame10.png", "frame2.png", false},
+		{"frame002.png", "frame10.png", true},
+		{"frame010.png", "frame9.png", false},
+		// Equal numbers with different padding still order deterministically.
+		{"frame02.png", "frame2.png", true},
+		{"frame2.png", "frame02.png", false},
+		{"Frame2.png", "frame10.png", true},
+		{"b.png", "A.png", false},
+		{"walk_1_2.png", "walk_1_10.png", true},
+		{"walk.png", "walk1.png", true},
+		{"frame2.png", "frame2.png", false},
+	} {
+		if got := naturalLess(tc.a, tc.b); got != tc.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestSortSpritesFolder(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"frame1.png", "frame10.png", "Frame3.png", "frame2.png", "frame001.png"}
+	// Modification times run backwards through names, frame001.png is the oldest and frame1.png the newest.
+	base := time.Now().Add(-time.Hour)
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mod_time := base.Add(time.Duration(len(names)-i) * time.Minute)
+		if err := os.Chtimes(path, mod_time, mod_time); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := "# opening frames\nframe10.png\n\n  frame2.png  \n# frame1.png is commented out\nmissing.png\nframe10.png\n"
+	if err := os.WriteFile(filepath.Join(dir, "order.txt"), []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = slices.DeleteFunc(entries, func(entry os.DirEntry) bool { return entry.Name() == "order.txt" })
+
+	for _, tc := range []struct {
+		order string
+		want  []string
+	}{
+		{"", []string{"frame001.png", "frame1.png", "frame2.png", "Frame3.png", "frame10.png"}},
+		{"natural", []string{"frame001.png", "frame1.png", "frame2.png", "Frame3.png", "frame10.png"}},
+		{"lexical", []string{"Frame3.png", "frame001.png", "frame1.png", "frame10.png", "frame2.png"}},
+		{"MTIME", []string{"frame001.png", "frame2.png", "Frame3.png", "frame10.png", "frame1.png"}},
+		// Listed frames first in list order, the rest after them in natural order.
+		{"order.txt", []string{"frame10.png", "frame2.png", "frame001.png", "frame1.png", "Frame3.png"}},
+	} {
+		sorted, err := sortSpritesFolder(entries, GontageArgs{Sprite_source_folder: dir, Frame_order: tc.order})
+		if err != nil {
+			t.Errorf("-order %q: %v", tc.order, err)
+			continue
+		}
+		var got []string
+		for _, entry := range sorted {
+			got = append(got, entry.Name())
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("-order %q: %v, want %v", tc.order, got, tc.want)
+		}
+	}
+
+	if _, err := sortSpritesFolder(entries, GontageArgs{Sprite_source_folder: dir, Frame_order: "random"}); err == nil {
+		t.Errorf("expected an error for an unknown order that isn't a file")
+	}
+}
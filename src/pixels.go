@@ -0,0 +1,77 @@
+package gontage
+
+import (
+	"image"
+	"image/draw"
+	"runtime"
+	"sync"
+)
+
+// parallelRows splits the rows [0, height) into one band per CPU and runs fn on the bands concurrently.
+func parallelRows(height int, fn func(y_start int, y_end int)) {
+	bands := min(runtime.GOMAXPROCS(0), height)
+	if bands <= 1 {
+		fn(0, height)
+		return
+	}
+	var rows_wg sync.WaitGroup
+	for band := 0; band < bands; band++ {
+		rows_wg.Add(1)
+		go func(y_start int, y_end int) {
+			defer rows_wg.Done()
+			fn(y_start, y_end)
+		}(band*height/bands, (band+1)*height/bands)
+	}
+	rows_wg.Wait()
+}
+
+// drawNRGBA is draw.Draw with draw.Src into an NRGBA image, copying whole rows of Pix when src is
+// NRGBA too instead of converting every pixel through color.Color.
+func drawNRGBA(dst *image.NRGBA, r image.Rectangle, src image.Image, sp image.Point) {
+	// Clip like draw.Draw does: to dst, and to the part of src that lines up with r.
+	unclipped_min := r.Min
+	r = r.Intersect(dst.Bounds()).Intersect(src.Bounds().Add(unclipped_min.Sub(sp)))
+	if r.Empty() {
+		return
+	}
+	sp = sp.Add(r.Min.Sub(unclipped_min))
+	nrgba, ok := src.(*image.NRGBA)
+	if !ok {
+		draw.Draw(dst, r, src, sp, draw.Src)
+		return
+	}
+	row_bytes := r.Dx() * 4
+	for y := 0; y < r.Dy(); y++ {
+		dst_offset := dst.PixOffset(r.Min.X, r.Min.Y+y)
+		src_offset := nrgba.PixOffset(sp.X, sp.Y+y)
+		copy(dst.Pix[dst_offset:dst_offset+row_bytes], nrgba.Pix[src_offset:src_offset+row_bytes])
+	}
+}
+
+// rgbaToNRGBA un-premultiplies img row by row, with the same rounding as color.NRGBAModel.
+func rgbaToNRGBA(img *image.RGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(bounds)
+	parallelRows(bounds.Dy(), func(y_start int, y_end int) {
+		for y := y_start; y < y_end; y++ {
+			src_row := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:bounds.Dx()*4]
+			dst_row := nrgba.Pix[nrgba.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:bounds.Dx()*4]
+			for i := 0; i < len(src_row); i += 4 {
+				a := uint32(src_row[i+3])
+				switch a {
+				case 0:
+					continue
+				case 0xff:
+					copy(dst_row[i:i+4], src_row[i:i+4])
+					continue
+				}
+				a *= 0x101
+				for ch := 0; ch < 3; ch++ {
+					dst_row[i+ch] = uint8((uint32(src_row[i+ch]) * 0x101 * 0xffff / a) >> 8)
+				}
+				dst_row[i+3] = src_row[i+3]
+			}
+		}
+	})
+	return nrgba
+}
@@ -0,0 +1,274 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"testing"
+)
+
+const benchmark_sheet = "../test/test_sprites/test_sprites_f187_v24.png"
+
+// applyFadingAtSet fades pixel by pixel through img.At and fadedImg.Set with colour model conversions,
+// using the same fade shapes as applyFading. It checks the Pix row version gives the same result.
+func applyFadingAtSet(img image.Image, gargs GontageArgs) *image.RGBA {
+	bounds := img.Bounds()
+	fadedImg := image.NewRGBA(bounds)
+	shape := newFadeShape(bounds, gargs)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			origColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			alphaMultiplier := shape.alpha(float64(x-bounds.Min.X), float64(y-bounds.Min.Y))
+			fadedImg.Set(x, y, color.RGBA{
+				R: uint8(float64(origColor.R) * alphaMultiplier),
+				G: uint8(float64(origColor.G) * alphaMultiplier),
+				B: uint8(float64(origColor.B) * alphaMultiplier),
+				A: uint8(float64(origColor.A) * alphaMultiplier),
+			})
+		}
+	}
+	return fadedImg
+}
+
+// applyFadingBaseline is applyFading as it was before the fade modes and easings were added (circle and
+// square only), kept unchanged as the benchmark baseline.
+func applyFadingBaseline(img image.Image, fadeAmount int, fadeMode string) *image.RGBA {
+	if fadeAmount <= 0 || fadeAmount > 100 {
+		// Convert to RGBA to maintain consistent return type
+		bounds := img.Bounds()
+		rgbaImg := image.NewRGBA(bounds)
+		draw.Draw(rgbaImg, bounds, img, bounds.Min, draw.Src)
+		return rgbaImg
+	}
+
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	// Create new RGBA image to handle transparency properly
+	fadedImg := image.NewRGBA(bounds)
+
+	// Calculate dimensions for fading
+	centerX := float64(width) / 2.0
+	centerY := float64(height) / 2.0
+
+	var fadeDistanceX, fadeDistanceY float64
+
+	if fadeMode == "s" {
+		// Square fading
+		fadeDistanceX = centerX * (float64(fadeAmount) / 100.0)
+		fadeDistanceY = centerY * (float64(fadeAmount) / 100.0)
+	} else {
+		// Circle fading (default)
+		maxRadius := math.Min(centerX, centerY)
+		fadeRadius := maxRadius * (float64(fadeAmount) / 100.0)
+		fadeDistanceX = fadeRadius
+		fadeDistanceY = fadeRadius
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Get original pixel - use direct RGBA conversion to avoid color space issues
+			origColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+
+			var alphaMultiplier float64 = 1.0
+
+			if fadeMode == "s" {
+				// Square fading
+				dx := math.Abs(float64(x) - centerX)
+				dy := math.Abs(float64(y) - centerY)
+
+				// Calculate fade progress based on both dimensions
+				var fadeProgress float64
+
+				if dx > centerX-fadeDistanceX && dy > centerY-fadeDistanceY {
+					// Corner region - use combined distance
+					xProgress := (dx - (centerX - fadeDistanceX)) / fadeDistanceX
+					yProgress := (dy - (centerY - fadeDistanceY)) / fadeDistanceY
+					fadeProgress = math.Max(xProgress, yProgress)
+				} else if dx > centerX-fadeDistanceX {
+					// X edge region
+					fadeProgress = (dx - (centerX - fadeDistanceX)) / fadeDistanceX
+				} else if dy > centerY-fadeDistanceY {
+					// Y edge region
+					fadeProgress = (dy - (centerY - fadeDistanceY)) / fadeDistanceY
+				} else {
+					// Inside the non-faded area
+					fadeProgress = 0
+				}
+
+				if fadeProgress >= 1.0 {
+					alphaMultiplier = 0.0 // Fully transparent
+				} else if fadeProgress <= 0 {
+					alphaMultiplier = 1.0 // Fully opaque
+				} else {
+					alphaMultiplier = 1.0 - fadeProgress
+				}
+			} else {
+				// Circle fading (default)
+				dx := float64(x) - centerX
+				dy := float64(y) - centerY
+				distance := math.Sqrt(dx*dx + dy*dy)
+				maxRadius := math.Min(centerX, centerY)
+
+				if distance <= maxRadius-fadeDistanceX {
+					// Inside the non-faded area - full opacity
+					alphaMultiplier = 1.0
+				} else if distance >= maxRadius {
+					// Outside the circle - fully transparent
+					alphaMultiplier = 0.0
+				} else {
+					// In the fade zone - gradient
+					fadeProgress := (distance - (maxRadius - fadeDistanceX)) / fadeDistanceX
+					alphaMultiplier = 1.0 - fadeProgress
+				}
+			}
+
+			// Apply alpha multiplier to original alpha and adjust RGB values to avoid artifacts
+			newAlpha := uint8(float64(origColor.A) * alphaMultiplier)
+			newR := uint8(float64(origColor.R) * alphaMultiplier)
+			newG := uint8(float64(origColor.G) * alphaMultiplier)
+			newB := uint8(float64(origColor.B) * alphaMultiplier)
+			fadedImg.Set(x, y, color.RGBA{
+				R: newR,
+				G: newG,
+				B: newB,
+				A: newAlpha,
+			})
+		}
+	}
+
+	return fadedImg
+}
+
+// benchmarkSprites cuts the test spritesheet into its 128x128 cells.
+func benchmarkSprites(b *testing.B) []image.Image {
+	b.Helper()
+	f, err := os.Open(benchmark_sheet)
+	if err != nil {
+		b.Skip(err)
+	}
+	defer f.Close()
+	sheet, _, err := image.Decode(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var sprites []image.Image
+	for _, r := range (cutGrid{cell_width: 128, cell_height: 128}).rects(sheet.Bounds(), -1) {
+		sprite := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(sprite, sprite.Bounds(), sheet, r.Min, draw.Src)
+		sprites = append(sprites, sprite)
+	}
+	return sprites
+}
+
+func TestApplyFadingMatchesAtSet(t *testing.T) {
+	sprite := image.NewRGBA(image.Rect(3, 5, 40, 29))
+	for i := range sprite.Pix {
+		sprite.Pix[i] = uint8(i * 7)
+	}
+	// Keep the premultiplied RGBA valid: colour can't exceed alpha.
+	for i := 0; i < len(sprite.Pix); i += 4 {
+		for ch := 0; ch < 3; ch++ {
+			sprite.Pix[i+ch] = min(sprite.Pix[i+ch], sprite.Pix[i+3])
+		}
+	}
+	for _, mode := range []string{fadeCircle, fadeSquare, fadeEllipse, fadeRounded, fadeLinear} {
+		gargs := GontageArgs{Fade_amount: 70, Fade_mode: mode, Fade_easing: "smoothstep", Fade_corner_radius: 25}
+		got, want := applyFading(sprite, gargs), applyFadingAtSet(sprite, gargs)
+		if got.Bounds() != want.Bounds() {
+			t.Fatalf("mode %s: bounds %v, want %v", mode, got.Bounds(), want.Bounds())
+		}
+		// The old implementation truncated the faded alpha where applyFading rounds it.
+		for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+			for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+				if diff := int(got.NRGBAAt(x, y).A) - int(want.RGBAAt(x, y).A); diff < 0 || diff > 1 {
+					t.Fatalf("mode %s: pixel %d,%d alpha %d, want %d", mode, x, y, got.NRGBAAt(x, y).A, want.RGBAAt(x, y).A)
+				}
+			}
+		}
+	}
+}
+
+func TestDrawNRGBAMatchesDraw(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(2, 1, 12, 9))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 13)
+	}
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 10, 8),
+		image.Rect(5, 3, 40, 40),
+		image.Rect(-4, -2, 6, 6),
+	} {
+		got := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		want := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		drawNRGBA(got, r, src, image.Pt(3, 2))
+		draw.Draw(want, r, src, image.Pt(3, 2), draw.Src)
+		for i := range want.Pix {
+			if got.Pix[i] != want.Pix[i] {
+				t.Fatalf("rect %v: byte %d = %d, want %d", r, i, got.Pix[i], want.Pix[i])
+			}
+		}
+	}
+}
+
+func TestToNRGBAFromRGBAMatchesModel(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for a := 0; a < 256; a++ {
+		src.SetRGBA(a, 0, color.RGBA{uint8(a * 3 / 4), uint8(a / 3), uint8(a), uint8(a)})
+	}
+	got := toNRGBA(src)
+	for x := 0; x < 256; x++ {
+		if want := color.NRGBAModel.Convert(src.RGBAAt(x, 0)).(color.NRGBA); got.NRGBAAt(x, 0) != want {
+			t.Fatalf("pixel %d = %v, want %v", x, got.NRGBAAt(x, 0), want)
+		}
+	}
+}
+
+func BenchmarkApplyFading(b *testing.B) {
+	sprites := benchmarkSprites(b)
+	gargs := GontageArgs{Fade_amount: 50, Fade_mode: fadeCircle}
+	b.ResetTimer()
+	for range b.N {
+		for _, sprite := range sprites {
+			applyFading(sprite, gargs)
+		}
+	}
+}
+
+func BenchmarkApplyFadingBaseline(b *testing.B) {
+	sprites := benchmarkSprites(b)
+	b.ResetTimer()
+	for range b.N {
+		for _, sprite := range sprites {
+			applyFadingBaseline(sprite, 50, fadeCircle)
+		}
+	}
+}
+
+func benchmarkDrawSheet(b *testing.B, draw_sprite func(sheet *image.NRGBA, r image.Rectangle, sprite image.Image)) {
+	sprites := benchmarkSprites(b)
+	hframes := 8
+	sheet := image.NewNRGBA(image.Rect(0, 0, 128*hframes, 128*((len(sprites)+hframes-1)/hframes)))
+	b.ResetTimer()
+	for range b.N {
+		for i, sprite := range sprites {
+			min := image.Pt((i%hframes)*128, (i/hframes)*128)
+			draw_sprite(sheet, image.Rectangle{min, min.Add(image.Pt(128, 128))}, sprite)
+		}
+	}
+}
+
+func BenchmarkDrawSpritesheet(b *testing.B) {
+	benchmarkDrawSheet(b, func(sheet *image.NRGBA, r image.Rectangle, sprite image.Image) {
+		drawNRGBA(sheet, r, sprite, image.Point{})
+	})
+}
+
+func BenchmarkDrawSpritesheetDrawPackage(b *testing.B) {
+	benchmarkDrawSheet(b, func(sheet *image.NRGBA, r image.Rectangle, sprite image.Image) {
+		draw.Draw(sheet, r, sprite, image.Point{}, draw.Src)
+	})
+}
@@ -0,0 +1,437 @@
+package gontage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Quantizers accepted by GontageArgs.Quantizer (-quant).
+const (
+	quantMedianCut = "median"
+	quantOctree    = "octree"
+)
+
+// Dither modes accepted by GontageArgs.Dither (-dither).
+const (
+	ditherNone           = "none"
+	ditherFloydSteinberg = "fs"
+	ditherOrdered        = "ordered"
+)
+
+// bayer8 is the 8x8 Bayer threshold matrix used by ordered dithering.
+var bayer8 = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+func validateQuantizeOptions(gargs GontageArgs) error {
+	if gargs.Palette_colors == 0 {
+		return nil
+	}
+	if gargs.Palette_colors < 2 || gargs.Palette_colors > 256 {
+		return fmt.Errorf("palette colours must be between 2 and 256, got %d", gargs.Palette_colors)
+	}
+	switch strings.ToLower(gargs.Quantizer) {
+	case "", quantMedianCut, quantOctree:
+	default:
+		return fmt.Errorf("unknown quantizer %q (expected median or octree)", gargs.Quantizer)
+	}
+	switch strings.ToLower(gargs.Dither) {
+	case "", ditherNone, ditherFloydSteinberg, ditherOrdered:
+	default:
+		return fmt.Errorf("unknown dither mode %q (expected none, fs or ordered)", gargs.Dither)
+	}
+	return nil
+}
+
+// quantizeImage reduces img to at most colors palette entries.
+// Fully transparent pixels share palette index 0 so the transparency survives as a single tRNS entry.
+func quantizeImage(img image.Image, colors int, quantizer string, dither string) *image.Paletted {
+	nrgba := toNRGBA(img)
+	bounds := nrgba.Bounds()
+
+	histogram := map[color.NRGBA]int{}
+	has_transparent := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := nrgba.NRGBAAt(x, y)
+			if c.A == 0 {
+				has_transparent = true
+				continue
+			}
+			histogram[c]++
+		}
+	}
+
+	max_colors := colors
+	var pal color.Palette
+	if has_transparent {
+		pal = append(pal, color.NRGBA{})
+		max_colors--
+	}
+	if len(histogram) <= max_colors {
+		for _, cc := range sortedColors(histogram) {
+			pal = append(pal, cc.c)
+		}
+		// Exact palette - dithering would only add noise.
+		dither = ditherNone
+	} else if strings.ToLower(quantizer) == quantOctree {
+		pal = append(pal, octreePalette(histogram, max_colors)...)
+	} else {
+		pal = append(pal, medianCutPalette(histogram, max_colors)...)
+	}
+
+	paletted := image.NewPaletted(bounds, pal)
+	mapper := newPaletteMapper(pal, has_transparent)
+	switch strings.ToLower(dither) {
+	case ditherFloydSteinberg:
+		ditherFloydSteinbergInto(paletted, nrgba, mapper)
+	case ditherOrdered:
+		ditherOrderedInto(paletted, nrgba, mapper, len(pal))
+	default:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				paletted.SetColorIndex(x, y, mapper.index(nrgba.NRGBAAt(x, y)))
+			}
+		}
+	}
+	return paletted
+}
+
+// paletteMapper finds the nearest palette entry for a colour, caching lookups.
+type paletteMapper struct {
+	palette         []color.NRGBA
+	has_transparent bool
+	cache           map[color.NRGBA]uint8
+}
+
+func newPaletteMapper(pal color.Palette, has_transparent bool) *paletteMapper {
+	mapper := &paletteMapper{
+		palette:         make([]color.NRGBA, len(pal)),
+		has_transparent: has_transparent,
+		cache:           map[color.NRGBA]uint8{},
+	}
+	for i, c := range pal {
+		mapper.palette[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+	return mapper
+}
+
+func (m *paletteMapper) index(c color.NRGBA) uint8 {
+	if c.A == 0 && m.has_transparent {
+		return 0
+	}
+	if i, ok := m.cache[c]; ok {
+		return i
+	}
+	best, best_distance := 0, math.MaxInt
+	for i, p := range m.palette {
+		if m.has_transparent && i == 0 {
+			continue
+		}
+		dr := int(c.R) - int(p.R)
+		dg := int(c.G) - int(p.G)
+		db := int(c.B) - int(p.B)
+		da := int(c.A) - int(p.A)
+		distance := dr*dr + dg*dg + db*db + da*da
+		if distance < best_distance {
+			best, best_distance = i, distance
+		}
+	}
+	m.cache[c] = uint8(best)
+	return uint8(best)
+}
+
+func clampByte(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func ditherFloydSteinbergInto(dst *image.Paletted, src *image.NRGBA, mapper *paletteMapper) {
+	bounds := src.Bounds()
+	width := bounds.Dx()
+	// Two rows of accumulated RGBA error, the current row and the next.
+	current := make([][4]float64, width+2)
+	next := make([][4]float64, width+2)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := x - bounds.Min.X + 1
+			c := src.NRGBAAt(x, y)
+			if c.A == 0 {
+				// Keep transparent pixels crisp and don't spread error into them.
+				dst.SetColorIndex(x, y, mapper.index(c))
+				continue
+			}
+			wanted := [4]float64{
+				float64(c.R) + current[i][0],
+				float64(c.G) + current[i][1],
+				float64(c.B) + current[i][2],
+				float64(c.A) + current[i][3],
+			}
+			adjusted := color.NRGBA{clampByte(wanted[0]), clampByte(wanted[1]), clampByte(wanted[2]), clampByte(wanted[3])}
+			if adjusted.A == 0 {
+				adjusted.A = 1
+			}
+			index := mapper.index(adjusted)
+			dst.SetColorIndex(x, y, index)
+			p := mapper.palette[index]
+			quant_error := [4]float64{
+				wanted[0] - float64(p.R),
+				wanted[1] - float64(p.G),
+				wanted[2] - float64(p.B),
+				wanted[3] - float64(p.A),
+			}
+			for ch := 0; ch < 4; ch++ {
+				current[i+1][ch] += quant_error[ch] * 7 / 16
+				next[i-1][ch] += quant_error[ch] * 3 / 16
+				next[i][ch] += quant_error[ch] * 5 / 16
+				next[i+1][ch] += quant_error[ch] * 1 / 16
+			}
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = [4]float64{}
+		}
+	}
+}
+
+func ditherOrderedInto(dst *image.Paletted, src *image.NRGBA, mapper *paletteMapper, colors int) {
+	bounds := src.Bounds()
+	// Spread roughly matches the gap between palette entries on each channel.
+	spread := 255.0 / math.Cbrt(float64(colors))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := src.NRGBAAt(x, y)
+			if c.A == 0 {
+				dst.SetColorIndex(x, y, mapper.index(c))
+				continue
+			}
+			offset := (bayer8[y&7][x&7]/64.0 - 0.5) * spread
+			adjusted := color.NRGBA{
+				clampByte(float64(c.R) + offset),
+				clampByte(float64(c.G) + offset),
+				clampByte(float64(c.B) + offset),
+				c.A,
+			}
+			dst.SetColorIndex(x, y, mapper.index(adjusted))
+		}
+	}
+}
+
+type colorCount struct {
+	c     color.NRGBA
+	count int
+}
+
+// sortedColors flattens histogram in a fixed order so the same input always gives the same palette.
+func sortedColors(histogram map[color.NRGBA]int) []colorCount {
+	colors := make([]colorCount, 0, len(histogram))
+	for c, n := range histogram {
+		colors = append(colors, colorCount{c, n})
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		a, b := colors[i].c, colors[j].c
+		return uint32(a.R)<<24|uint32(a.G)<<16|uint32(a.B)<<8|uint32(a.A) <
+			uint32(b.R)<<24|uint32(b.G)<<16|uint32(b.B)<<8|uint32(b.A)
+	})
+	return colors
+}
+
+func channel(c color.NRGBA, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 2:
+		return c.B
+	}
+	return c.A
+}
+
+func averageColor(colors []colorCount) color.NRGBA {
+	var r, g, b, a, total float64
+	for _, cc := range colors {
+		n := float64(cc.count)
+		r += float64(cc.c.R) * n
+		g += float64(cc.c.G) * n
+		b += float64(cc.c.B) * n
+		a += float64(cc.c.A) * n
+		total += n
+	}
+	return color.NRGBA{clampByte(r / total), clampByte(g / total), clampByte(b / total), clampByte(a / total)}
+}
+
+// medianCutPalette repeatedly splits the colour box with the widest weighted channel range at its median.
+func medianCutPalette(histogram map[color.NRGBA]int, max_colors int) color.Palette {
+	boxes := [][]colorCount{sortedColors(histogram)}
+	for len(boxes) < max_colors {
+		split_box, split_channel, best_score := -1, 0, 0.0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			population := 0
+			for _, cc := range box {
+				population += cc.count
+			}
+			for ch := 0; ch < 4; ch++ {
+				lo, hi := uint8(255), uint8(0)
+				for _, cc := range box {
+					v := channel(cc.c, ch)
+					lo = min(lo, v)
+					hi = max(hi, v)
+				}
+				score := float64(hi-lo) * math.Sqrt(float64(population))
+				if score > best_score {
+					split_box, split_channel, best_score = i, ch, score
+				}
+			}
+		}
+		if split_box == -1 {
+			break
+		}
+
+		box := boxes[split_box]
+		sort.SliceStable(box, func(a, b int) bool {
+			return channel(box[a].c, split_channel) < channel(box[b].c, split_channel)
+		})
+		population := 0
+		for _, cc := range box {
+			population += cc.count
+		}
+		median, running := 1, 0
+		for i, cc := range box {
+			running += cc.count
+			if running*2 >= population {
+				median = max(1, min(i+1, len(box)-1))
+				break
+			}
+		}
+		boxes[split_box] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		pal = append(pal, averageColor(box))
+	}
+	return pal
+}
+
+type octreeNode struct {
+	r, g, b, a float64
+	count      int
+	children   [8]*octreeNode
+	is_leaf    bool
+}
+
+// octreePalette builds an 8 level RGB octree and folds the least populated nodes until max_colors leaves remain.
+func octreePalette(histogram map[color.NRGBA]int, max_colors int) color.Palette {
+	const depth = 8
+	root := &octreeNode{}
+	levels := make([][]*octreeNode, depth)
+	leaves := 0
+	for _, cc := range sortedColors(histogram) {
+		c, n := cc.c, cc.count
+		node := root
+		for level := 0; level < depth; level++ {
+			shift := 7 - level
+			child_index := (int(c.R>>shift)&1)<<2 | (int(c.G>>shift)&1)<<1 | int(c.B>>shift)&1
+			if node.children[child_index] == nil {
+				child := &octreeNode{is_leaf: level == depth-1}
+				node.children[child_index] = child
+				if child.is_leaf {
+					leaves++
+				} else {
+					levels[level] = append(levels[level], child)
+				}
+			}
+			node = node.children[child_index]
+		}
+		node.r += float64(c.R) * float64(n)
+		node.g += float64(c.G) * float64(n)
+		node.b += float64(c.B) * float64(n)
+		node.a += float64(c.A) * float64(n)
+		node.count += n
+	}
+
+	// Reduce from the deepest level up, folding the smallest nodes first.
+	for level := depth - 2; level >= 0 && leaves > max_colors; level-- {
+		nodes := levels[level]
+		for _, node := range nodes {
+			node.count = subtreeCount(node)
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		for _, node := range nodes {
+			if leaves <= max_colors {
+				break
+			}
+			merged := 0
+			node.count = 0
+			for i, child := range node.children {
+				if child == nil {
+					continue
+				}
+				node.r += child.r
+				node.g += child.g
+				node.b += child.b
+				node.a += child.a
+				node.count += child.count
+				node.children[i] = nil
+				merged++
+			}
+			node.is_leaf = true
+			leaves -= merged - 1
+		}
+	}
+	if leaves > max_colors {
+		// Everything folded into a single level; fall back to median cut for the final cut down.
+		return medianCutPalette(histogram, max_colors)
+	}
+
+	var pal color.Palette
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.is_leaf {
+			n := float64(node.count)
+			pal = append(pal, color.NRGBA{clampByte(node.r / n), clampByte(node.g / n), clampByte(node.b / n), clampByte(node.a / n)})
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+	return pal
+}
+
+func subtreeCount(node *octreeNode) int {
+	if node.is_leaf {
+		return node.count
+	}
+	total := 0
+	for _, child := range node.children {
+		if child != nil {
+			total += subtreeCount(child)
+		}
+	}
+	return total
+}
@@ -0,0 +1,69 @@
+package gontage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestQuantizeImage(t *testing.T) {
+	// A colourful gradient with fully transparent and semi-transparent areas.
+	src := testImage(48, 40, func(x, y int) color.NRGBA {
+		switch {
+		case x < 8:
+			// left transparent, with leftover colour that must not matter
+			return color.NRGBA{uint8(x * 30), uint8(y * 6), 90, 0}
+		case y >= 32:
+			return color.NRGBA{200, uint8(x * 5), 40, uint8(x * 5)}
+		default:
+			return color.NRGBA{uint8(x * 5), uint8(y * 8), uint8((x + y) * 3), 255}
+		}
+	})
+	for _, quantizer := range []string{quantMedianCut, quantOctree} {
+		for _, dither := range []string{ditherNone, ditherFloydSteinberg, ditherOrdered} {
+			for _, colors := range []int{2, 16, 64, 256} {
+				name := fmt.Sprintf("%s/%s/%d", quantizer, dither, colors)
+				quantized := quantizeImage(src, colors, quantizer, dither)
+				if len(quantized.Palette) > colors {
+					t.Errorf("%s: %d palette entries, want at most %d", name, len(quantized.Palette), colors)
+				}
+				for y := 0; y < 40; y++ {
+					for x := 0; x < 48; x++ {
+						_, _, _, a := quantized.At(x, y).RGBA()
+						if transparent := src.NRGBAAt(x, y).A == 0; transparent != (a == 0) {
+							t.Fatalf("%s: pixel %d,%d has alpha %d, source alpha %d", name, x, y, a>>8, src.NRGBAAt(x, y).A)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestQuantizeImageKeepsExactColours(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 12, 9))
+	colours := []color.NRGBA{
+		{0, 0, 0, 0}, {255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {10, 20, 30, 255},
+		{250, 240, 230, 255}, {128, 128, 128, 128}, {60, 0, 90, 40}, {1, 2, 3, 255}, {77, 66, 55, 255},
+	}
+	for i := range src.Pix {
+		if i%4 == 0 {
+			c := colours[(i/4*7)%len(colours)]
+			copy(src.Pix[i:i+4], []uint8{c.R, c.G, c.B, c.A})
+		}
+	}
+	for _, quantizer := range []string{quantMedianCut, quantOctree} {
+		for _, dither := range []string{ditherNone, ditherFloydSteinberg, ditherOrdered} {
+			quantized := quantizeImage(src, 16, quantizer, dither)
+			for y := 0; y < 9; y++ {
+				for x := 0; x < 12; x++ {
+					got := color.NRGBAModel.Convert(quantized.At(x, y)).(color.NRGBA)
+					if want := src.NRGBAAt(x, y); got != want {
+						t.Fatalf("%s/%s: pixel %d,%d = %v, want %v", quantizer, dither, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package gontage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recolorPalette is one -palettes entry, its colours line up with the -recolor source palette.
+type recolorPalette struct {
+	name   string
+	colors []color.NRGBA
+}
+
+// isRecoloring reports whether -recolor is set.
+func isRecoloring(gargs GontageArgs) bool {
+	return gargs.Recolor_source != ""
+}
+
+// parsePalette reads a palette from an image (its distinct opaque colours, left to right and top to
+// bottom) or from a comma separated hex list like "b22222,8b0000,ff6347".
+func parsePalette(spec string) ([]color.NRGBA, error) {
+	spec = strings.TrimSpace(spec)
+	if sourceImageExts[strings.ToLower(filepath.Ext(spec))] {
+		return paletteFromImage(spec)
+	}
+	var colors []color.NRGBA
+	for _, hex := range strings.Split(spec, ",") {
+		c, err := parseHexColor(hex)
+		if err != nil {
+			return nil, fmt.Errorf("palette %q: %v", spec, err)
+		}
+		colors = append(colors, c)
+	}
+	return colors, nil
+}
+
+func paletteFromImage(path string) ([]color.NRGBA, error) {
+	decoded, err := decodeImageFile(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("reading palette %s: %v", path, err)
+	}
+	img := toNRGBA(decoded)
+	bounds := img.Bounds()
+	seen := map[color.NRGBA]bool{}
+	var colors []color.NRGBA
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A == 0 || seen[c] {
+				continue
+			}
+			seen[c] = true
+			colors = append(colors, c)
+		}
+	}
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("palette %s has no opaque colours", path)
+	}
+	return colors, nil
+}
+
+// parseRecolorTargets parses -palettes: ';' separated palettes, each optionally named with "name=".
+// Unnamed palette images are named after the file, unnamed hex lists by their position.
+func parseRecolorTargets(gargs GontageArgs) ([]recolorPalette, error) {
+	if strings.TrimSpace(gargs.Recolor_targets) == "" {
+		return nil, fmt.Errorf("-recolor needs at least one target palette, set them with -palettes")
+	}
+	var targets []recolorPalette
+	for i, entry := range strings.Split(gargs.Recolor_targets, ";") {
+		name, spec, named := strings.Cut(strings.TrimSpace(entry), "=")
+		if !named {
+			spec = name
+			name = strconv.Itoa(i + 1)
+			if sourceImageExts[strings.ToLower(filepath.Ext(spec))] {
+				name = strings.TrimSuffix(filepath.Base(spec), filepath.Ext(spec))
+			}
+		}
+		colors, err := parsePalette(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, recolorPalette{name: strings.TrimSpace(name), colors: colors})
+	}
+	return targets, nil
+}
+
+func validateRecolorOptions(gargs GontageArgs) error {
+	if !isRecoloring(gargs) {
+		if gargs.Recolor_targets != "" {
+			return fmt.Errorf("-palettes needs a source palette, set it with -recolor")
+		}
+		return nil
+	}
+	if gargs.Cut_spritesheet != "" || gargs.Cut_grid != "" {
+		return fmt.Errorf("-recolor works on spritesheets and -ss sprites, not when cutting with -x")
+	}
+	if gargs.Recolor_tolerance < 0 {
+		return fmt.Errorf("recolour tolerance can't be negative")
+	}
+	source, err := parsePalette(gargs.Recolor_source)
+	if err != nil {
+		return err
+	}
+	targets, err := parseRecolorTargets(gargs)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, target := range targets {
+		if len(target.colors) != len(source) {
+			return fmt.Errorf("palette %q has %d colours, the source palette has %d", target.name, len(target.colors), len(source))
+		}
+		if target.name == "" {
+			return fmt.Errorf("palette names can't be empty")
+		}
+		if names[target.name] {
+			return fmt.Errorf("palette name %q is used twice", target.name)
+		}
+		names[target.name] = true
+	}
+	return nil
+}
+
+// recolorSprite swaps every source palette colour for the matching target colour. With -rtol, pixels
+// within that RGB distance of a source colour are swapped too and keep their difference from it,
+// so shading around a palette colour carries over. Alpha is left as it is.
+func recolorSprite(img image.Image, source []color.NRGBA, target []color.NRGBA, tolerance float64) *image.NRGBA {
+	src := toNRGBA(img)
+	bounds := src.Bounds()
+	recolored := image.NewNRGBA(bounds)
+	parallelRows(bounds.Dy(), func(y_start int, y_end int) {
+		// Sprites reuse few colours, so remember the closest source colour for each one seen.
+		closest := map[[3]uint8]int{}
+		for y := y_start; y < y_end; y++ {
+			src_row := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:bounds.Dx()*4]
+			dst_row := recolored.Pix[recolored.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:bounds.Dx()*4]
+			copy(dst_row, src_row)
+			for i := 0; i < len(dst_row); i += 4 {
+				if dst_row[i+3] == 0 {
+					continue
+				}
+				rgb := [3]uint8{dst_row[i], dst_row[i+1], dst_row[i+2]}
+				index, ok := closest[rgb]
+				if !ok {
+					index = closestPaletteColor(rgb, source, tolerance)
+					closest[rgb] = index
+				}
+				if index < 0 {
+					continue
+				}
+				from := [3]uint8{source[index].R, source[index].G, source[index].B}
+				to := [3]uint8{target[index].R, target[index].G, target[index].B}
+				for ch := 0; ch < 3; ch++ {
+					dst_row[i+ch] = uint8(max(0, min(255, int(to[ch])+int(rgb[ch])-int(from[ch]))))
+				}
+			}
+		}
+	})
+	return recolored
+}
+
+// closestPaletteColor is the index of the palette colour nearest rgb, or -1 if none is within tolerance.
+func closestPaletteColor(rgb [3]uint8, palette []color.NRGBA, tolerance float64) int {
+	best, best_distance := -1, math.Inf(1)
+	for i, c := range palette {
+		dr := float64(rgb[0]) - float64(c.R)
+		dg := float64(rgb[1]) - float64(c.G)
+		db := float64(rgb[2]) - float64(c.B)
+		distance := math.Sqrt(dr*dr + dg*dg + db*db)
+		if distance <= tolerance && distance < best_distance {
+			best, best_distance = i, distance
+		}
+	}
+	return best
+}
+
+// recolorVariants runs the spritesheet (or -ss) output once per -palettes entry, named after the
+// source folder with the palette name appended, e.g. barrel_blue_f19_v3.png.
+func recolorVariants(gargs GontageArgs, all_decoded_images []image.Image, all_decoded_images_names []string, start time.Time) {
+	// The options were validated before decoding, so these can't fail.
+	source, _ := parsePalette(gargs.Recolor_source)
+	targets, _ := parseRecolorTargets(gargs)
+	tolerance := float64(gargs.Recolor_tolerance)
+	for _, target := range targets {
+		variant_gargs := gargs
+		variant_gargs.Sprite_source_folder = fmt.Sprintf("%v_%v", gargs.Sprite_source_folder, target.name)
+		variant_images := make([]image.Image, len(all_decoded_images))
+		for i, decoded_image := range all_decoded_images {
+			variant_images[i] = recolorSprite(decoded_image, source, target.colors, tolerance)
+		}
+		if gargs.Single_sprites {
+			spritesToResizedSprites(variant_gargs, variant_images, all_decoded_images_names, start)
+		} else {
+			spritesToSpritesheet(variant_gargs, variant_images, all_decoded_images_names, start)
+		}
+	}
+}
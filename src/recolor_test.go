@@ -0,0 +1,68 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecolorSprite(t *testing.T) {
+	sprite := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	sprite.SetNRGBA(0, 0, color.NRGBA{200, 0, 0, 255})
+	sprite.SetNRGBA(1, 0, color.NRGBA{180, 10, 0, 128})
+	sprite.SetNRGBA(2, 0, color.NRGBA{0, 200, 0, 255})
+	sprite.SetNRGBA(3, 0, color.NRGBA{100, 100, 100, 255})
+	source := []color.NRGBA{{200, 0, 0, 255}, {0, 200, 0, 255}}
+	target := []color.NRGBA{{0, 0, 200, 255}, {250, 250, 0, 255}}
+
+	exact := recolorSprite(sprite, source, target, 0)
+	for x, want := range []color.NRGBA{{0, 0, 200, 255}, {180, 10, 0, 128}, {250, 250, 0, 255}, {100, 100, 100, 255}} {
+		if got := exact.NRGBAAt(x, 0); got != want {
+			t.Errorf("exact: pixel %d = %v, want %v", x, got, want)
+		}
+	}
+	// Within the tolerance the shading difference carries over and alpha stays.
+	shaded := recolorSprite(sprite, source, target, 30)
+	for x, want := range []color.NRGBA{{0, 0, 200, 255}, {0, 10, 200, 128}, {250, 250, 0, 255}, {100, 100, 100, 255}} {
+		if got := shaded.NRGBAAt(x, 0); got != want {
+			t.Errorf("tolerance: pixel %d = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestParseRecolorTargets(t *testing.T) {
+	dir := t.TempDir()
+	palette := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	palette.SetNRGBA(0, 0, color.NRGBA{1, 2, 3, 255})
+	palette.SetNRGBA(1, 0, color.NRGBA{4, 5, 6, 255})
+	palette.SetNRGBA(0, 1, color.NRGBA{1, 2, 3, 255})
+	palette_path := filepath.Join(dir, "yellow.png")
+	writeTestPng(t, palette_path, palette)
+
+	targets, err := parseRecolorTargets(GontageArgs{Recolor_targets: "blue=0000ff,000080;" + palette_path + "; 00ff00,008000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []recolorPalette{
+		{"blue", []color.NRGBA{{0, 0, 255, 255}, {0, 0, 128, 255}}},
+		{"yellow", []color.NRGBA{{1, 2, 3, 255}, {4, 5, 6, 255}}},
+		{"3", []color.NRGBA{{0, 255, 0, 255}, {0, 128, 0, 255}}},
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("got %d palettes, want %d", len(targets), len(want))
+	}
+	for i := range want {
+		if targets[i].name != want[i].name || len(targets[i].colors) != len(want[i].colors) {
+			t.Fatalf("palette %d = %v, want %v", i, targets[i], want[i])
+		}
+		for j := range want[i].colors {
+			if targets[i].colors[j] != want[i].colors[j] {
+				t.Errorf("palette %d colour %d = %v, want %v", i, j, targets[i].colors[j], want[i].colors[j])
+			}
+		}
+	}
+	if err := validateRecolorOptions(GontageArgs{Recolor_source: "ff0000", Recolor_targets: "0000ff,000080"}); err == nil {
+		t.Errorf("expected an error for palettes of different lengths")
+	}
+}
@@ -0,0 +1,203 @@
+package gontage
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// Resize modes accepted by GontageArgs.Resize_mode (-rm).
+const (
+	resizeStretch = "stretch"
+	resizeFit     = "fit"
+	resizeFill    = "fill"
+	resizePad     = "pad"
+)
+
+// Resampling filters accepted by GontageArgs.Resize_filter (-rf).
+// filterPixelArt scales by whole factors with exact pixel replication and falls back to nearest-neighbour.
+var resizeFilters = map[string]resize.InterpolationFunction{
+	"nearest":  resize.NearestNeighbor,
+	"bilinear": resize.Bilinear,
+	"bicubic":  resize.Bicubic,
+	"mitchell": resize.MitchellNetravali,
+	"lanczos2": resize.Lanczos2,
+	"lanczos3": resize.Lanczos3,
+}
+
+const filterPixelArt = "pixel"
+
+// ParseResizeSize parses -sr values: "64" for 64x64 or "64x32" for width x height.
+func ParseResizeSize(size string) (int, int, error) {
+	if size == "" {
+		return 0, 0, nil
+	}
+	parts := strings.Split(strings.ToLower(size), "x")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("invalid resize size %q (expected e.g. 64 or 64x32)", size)
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid resize width in %q", size)
+	}
+	height := width
+	if len(parts) == 2 {
+		height, err = strconv.Atoi(parts[1])
+		if err != nil || height <= 0 {
+			return 0, 0, fmt.Errorf("invalid resize height in %q", size)
+		}
+	}
+	return width, height, nil
+}
+
+func validateResizeOptions(gargs GontageArgs) error {
+	switch strings.ToLower(gargs.Resize_mode) {
+	case "", resizeStretch, resizeFit, resizeFill, resizePad:
+	default:
+		return fmt.Errorf("unknown resize mode %q (expected stretch, fit, fill or pad)", gargs.Resize_mode)
+	}
+	filter := strings.ToLower(gargs.Resize_filter)
+	if _, ok := resizeFilters[filter]; !ok && filter != "" && filter != filterPixelArt && !isUpscaleFilter(filter) {
+		return fmt.Errorf("unknown resize filter %q (expected nearest, bilinear, bicubic, mitchell, lanczos2, lanczos3, pixel, scalex, hqlike or xbr)", gargs.Resize_filter)
+	}
+	if gargs.Linear_resize && (filter == "nearest" || filter == filterPixelArt) {
+		return fmt.Errorf("-linear has no effect with -rf %s, which copies pixels without blending them", filter)
+	}
+	return nil
+}
+
+func isResizing(gargs GontageArgs) bool {
+	return gargs.Sprite_resize_width > 0 && gargs.Sprite_resize_height > 0
+}
+
+// resizeSuffix names resized output: "64px" for square sizes (as before) or "64x32px".
+func resizeSuffix(gargs GontageArgs) string {
+	if gargs.Sprite_resize_width == gargs.Sprite_resize_height {
+		return fmt.Sprintf("%dpx", gargs.Sprite_resize_width)
+	}
+	return fmt.Sprintf("%dx%dpx", gargs.Sprite_resize_width, gargs.Sprite_resize_height)
+}
+
+// resizeSprite resizes img to the -sr size using the -rm mode:
+// stretch ignores aspect ratio, fit scales to fit inside the box, fill scales to cover the box and
+// crops the overflow from the centre, pad fits and then centres on a transparent canvas of the box size.
+func resizeSprite(img image.Image, gargs GontageArgs) image.Image {
+	target_width, target_height := gargs.Sprite_resize_width, gargs.Sprite_resize_height
+	bounds := img.Bounds()
+	source_width, source_height := float64(bounds.Dx()), float64(bounds.Dy())
+	scale_x := float64(target_width) / source_width
+	scale_y := float64(target_height) / source_height
+
+	switch strings.ToLower(gargs.Resize_mode) {
+	case resizeFit:
+		scale := math.Min(scale_x, scale_y)
+		return resizeTo(img, gargs, scaledSize(source_width, scale), scaledSize(source_height, scale))
+	case resizeFill:
+		scale := math.Max(scale_x, scale_y)
+		scaled := resizeTo(img, gargs, scaledSize(source_width, scale), scaledSize(source_height, scale))
+		scaled_bounds := scaled.Bounds()
+		offset := image.Pt((scaled_bounds.Dx()-target_width)/2, (scaled_bounds.Dy()-target_height)/2)
+		cropped := image.NewNRGBA(image.Rect(0, 0, target_width, target_height))
+		drawNRGBA(cropped, cropped.Bounds(), scaled, scaled_bounds.Min.Add(offset))
+		return cropped
+	case resizePad:
+		scale := math.Min(scale_x, scale_y)
+		scaled := resizeTo(img, gargs, scaledSize(source_width, scale), scaledSize(source_height, scale))
+		scaled_bounds := scaled.Bounds()
+		padded := image.NewNRGBA(image.Rect(0, 0, target_width, target_height))
+		offset := image.Pt((target_width-scaled_bounds.Dx())/2, (target_height-scaled_bounds.Dy())/2)
+		drawNRGBA(padded, scaled_bounds.Sub(scaled_bounds.Min).Add(offset), scaled, scaled_bounds.Min)
+		return padded
+	default:
+		return resizeTo(img, gargs, target_width, target_height)
+	}
+}
+
+// ParseScales parses -scales values such as "1,2,3" or "1,1.5,2".
+func ParseScales(scales string) ([]float64, error) {
+	if scales == "" {
+		return nil, nil
+	}
+	var parsed []float64
+	for _, part := range strings.Split(scales, ",") {
+		scale, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(part)), "x"), 64)
+		if err != nil || scale <= 0 {
+			return nil, fmt.Errorf("invalid scale %q in %q (expected e.g. 1,2,3)", part, scales)
+		}
+		parsed = append(parsed, scale)
+	}
+	return parsed, nil
+}
+
+// scaleSuffix names a -scales variant, e.g. "@2x" or "@1.5x".
+func scaleSuffix(scale float64) string {
+	return "@" + strconv.FormatFloat(scale, 'f', -1, 64) + "x"
+}
+
+// scaleSprites resizes every sprite by scale with the -rf filter, keeping their order.
+func scaleSprites(gargs GontageArgs, all_decoded_images []image.Image, scale float64) []image.Image {
+	scaled_images := make([]image.Image, len(all_decoded_images))
+	var scale_wg sync.WaitGroup
+	for i, decoded_image := range all_decoded_images {
+		scale_wg.Add(1)
+		go func(i int, decoded_image image.Image) {
+			defer scale_wg.Done()
+			bounds := decoded_image.Bounds()
+			scaled_images[i] = resizeTo(decoded_image, gargs, scaledSize(float64(bounds.Dx()), scale), scaledSize(float64(bounds.Dy()), scale))
+		}(i, decoded_image)
+	}
+	scale_wg.Wait()
+	return scaled_images
+}
+
+func scaledSize(size float64, scale float64) int {
+	return max(1, int(math.Round(size*scale)))
+}
+
+// resizeTo is the single place images are resampled.
+func resizeTo(img image.Image, gargs GontageArgs, width int, height int) image.Image {
+	filter := strings.ToLower(gargs.Resize_filter)
+	if filter == filterPixelArt {
+		bounds := img.Bounds()
+		if width%bounds.Dx() == 0 && height%bounds.Dy() == 0 {
+			return scaleInteger(img, width/bounds.Dx(), height/bounds.Dy())
+		}
+		return resize.Resize(uint(width), uint(height), img, resize.NearestNeighbor)
+	}
+	if isUpscaleFilter(filter) {
+		return upscaleTo(img, filter, width, height, gargs.Linear_resize)
+	}
+	interpolation, ok := resizeFilters[filter]
+	if !ok {
+		interpolation = resize.Lanczos3
+	}
+	if gargs.Linear_resize && interpolation != resize.NearestNeighbor {
+		return resizeLinear(img, width, height, interpolation)
+	}
+	return resize.Resize(uint(width), uint(height), img, interpolation)
+}
+
+// scaleInteger upscales img by whole factors, repeating each source pixel into a factor_x by factor_y block.
+func scaleInteger(img image.Image, factor_x int, factor_y int) *image.NRGBA {
+	src := toNRGBA(img)
+	bounds := src.Bounds()
+	scaled := image.NewNRGBA(image.Rect(0, 0, bounds.Dx()*factor_x, bounds.Dy()*factor_y))
+	for y := 0; y < bounds.Dy(); y++ {
+		src_row := src.Pix[y*src.Stride : y*src.Stride+bounds.Dx()*4]
+		dst_row := scaled.Pix[y*factor_y*scaled.Stride : y*factor_y*scaled.Stride+scaled.Stride]
+		for x := 0; x < bounds.Dx(); x++ {
+			for fx := 0; fx < factor_x; fx++ {
+				copy(dst_row[(x*factor_x+fx)*4:(x*factor_x+fx)*4+4], src_row[x*4:x*4+4])
+			}
+		}
+		for fy := 1; fy < factor_y; fy++ {
+			copy(scaled.Pix[(y*factor_y+fy)*scaled.Stride:(y*factor_y+fy+1)*scaled.Stride], dst_row)
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,164 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestLinearResizeKeepsEdgeColour(t *testing.T) {
+	// Opaque red on the left, fully transparent black on the right.
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	fillTestRect(src, image.Rect(0, 0, 4, 8), color.NRGBA{255, 0, 0, 255})
+	gargs := GontageArgs{Resize_filter: "lanczos3", Linear_resize: true}
+	resized := toNRGBA(resizeTo(src, gargs, 4, 4))
+
+	found_edge := false
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := resized.NRGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			if c.A < 255 {
+				found_edge = true
+			}
+			// The reference edge colour is the sprite's own red, only alpha may fade.
+			if c.R < 254 || c.G > 1 || c.B > 1 {
+				t.Errorf("pixel %d,%d = %v, want red with partial alpha", x, y, c)
+			}
+		}
+	}
+	if !found_edge {
+		t.Fatalf("expected partially transparent edge pixels in %v", resized.Pix)
+	}
+}
+
+func TestLinearResizeAveragesInLinearLight(t *testing.T) {
+	// A one pixel black/white checker averages to 50% linear light, which is sRGB 188, not 128.
+	src := testImage(8, 8, func(x, y int) color.NRGBA {
+		v := uint8((x + y + 1) % 2 * 255)
+		return color.NRGBA{v, v, v, 255}
+	})
+	reference := uint8(math.Round(linearToSrgb(0.5) * 255))
+
+	gargs := GontageArgs{Resize_filter: "bilinear", Linear_resize: true}
+	resized := toNRGBA(resizeTo(src, gargs, 4, 4))
+	for y := 1; y < 3; y++ {
+		for x := 1; x < 3; x++ {
+			c := resized.NRGBAAt(x, y)
+			if diff := math.Abs(float64(c.R) - float64(reference)); diff > 2 || c.A != 255 {
+				t.Errorf("pixel %d,%d = %v, want grey %d", x, y, c, reference)
+			}
+		}
+	}
+
+	gargs.Linear_resize = false
+	gamma := toNRGBA(resizeTo(src, gargs, 4, 4)).NRGBAAt(1, 1)
+	if math.Abs(float64(gamma.R)-float64(reference)) <= 2 {
+		t.Errorf("gamma space resize unexpectedly matched the linear reference: %v", gamma)
+	}
+}
+
+func TestValidateResizeOptionsLinear(t *testing.T) {
+	for filter, ok := range map[string]bool{
+		"":           true,
+		"bilinear":   true,
+		"lanczos3":   true,
+		filterXbr:    true,
+		filterScaleX: true,
+		"nearest":    false,
+		"Nearest":    false,
+		"pixel":      false,
+	} {
+		err := validateResizeOptions(GontageArgs{Resize_filter: filter, Linear_resize: true})
+		if (err == nil) != ok {
+			t.Errorf("-rf %q -linear: error %v, want ok %v", filter, err, ok)
+		}
+	}
+}
+
+func TestParseResizeSize(t *testing.T) {
+	for _, tc := range []struct {
+		size          string
+		width, height int
+		ok            bool
+	}{
+		{"", 0, 0, true},
+		{"64", 64, 64, true},
+		{"64x32", 64, 32, true},
+		{"64X32", 64, 32, true},
+		{"0", 0, 0, false},
+		{"-4", 0, 0, false},
+		{"x32", 0, 0, false},
+		{"64x", 0, 0, false},
+		{"64x0", 0, 0, false},
+		{"64x32x2", 0, 0, false},
+		{"big", 0, 0, false},
+	} {
+		width, height, err := ParseResizeSize(tc.size)
+		if (err == nil) != tc.ok || width != tc.width || height != tc.height {
+			t.Errorf("ParseResizeSize(%q) = %d, %d, %v, want %d, %d, ok %v", tc.size, width, height, err, tc.width, tc.height, tc.ok)
+		}
+	}
+}
+
+func TestResizeSpriteModes(t *testing.T) {
+	// An 8x4 sprite where every column has its own colour, so crops and offsets show which columns are kept.
+	column := func(x int) color.NRGBA { return color.NRGBA{uint8(x * 30), 100, 200, 255} }
+	src := testImage(8, 4, func(x, _ int) color.NRGBA { return column(x) })
+	for _, tc := range []struct {
+		mode string
+		size image.Point
+		// pixels checked on the resized sprite
+		want map[image.Point]color.NRGBA
+	}{
+		{resizeStretch, image.Pt(16, 16), map[image.Point]color.NRGBA{
+			{0, 0}: column(0), {15, 15}: column(7), {4, 8}: column(2),
+		}},
+		// Fit keeps the aspect ratio, 2x gives 16x8.
+		{resizeFit, image.Pt(16, 8), map[image.Point]color.NRGBA{
+			{0, 0}: column(0), {15, 7}: column(7),
+		}},
+		// Fill scales 4x to 32x16 and crops 8px from each side, leaving source columns 2 to 5.
+		{resizeFill, image.Pt(16, 16), map[image.Point]color.NRGBA{
+			{0, 0}: column(2), {15, 15}: column(5), {8, 8}: column(4),
+		}},
+		// Pad fits to 16x8 and centres it with 4 transparent rows above and below.
+		{resizePad, image.Pt(16, 16), map[image.Point]color.NRGBA{
+			{0, 3}: {}, {0, 4}: column(0), {15, 11}: column(7), {15, 12}: {},
+		}},
+	} {
+		gargs := GontageArgs{Sprite_resize_width: 16, Sprite_resize_height: 16, Resize_mode: tc.mode, Resize_filter: filterPixelArt}
+		resized := toNRGBA(resizeSprite(src, gargs))
+		if size := resized.Bounds().Size(); size != tc.size {
+			t.Errorf("%s: size %v, want %v", tc.mode, size, tc.size)
+			continue
+		}
+		for p, want := range tc.want {
+			if got := resized.NRGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", tc.mode, p, got, want)
+			}
+		}
+	}
+}
+
+func TestResizeSpriteNonSquareBox(t *testing.T) {
+	// A square sprite padded into a 12x6 box is centred horizontally.
+	src := solidTestImage(3, 3, color.NRGBA{255, 255, 255, 255})
+	gargs := GontageArgs{Sprite_resize_width: 12, Sprite_resize_height: 6, Resize_mode: resizePad, Resize_filter: filterPixelArt}
+	resized := toNRGBA(resizeSprite(src, gargs))
+	if size := resized.Bounds().Size(); size != image.Pt(12, 6) {
+		t.Fatalf("size %v, want 12x6", size)
+	}
+	for x := 0; x < 12; x++ {
+		want := uint8(0)
+		if x >= 3 && x < 9 {
+			want = 255
+		}
+		if got := resized.NRGBAAt(x, 0).A; got != want {
+			t.Errorf("column %d alpha %d, want %d", x, got, want)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// Pixel-art upscalers accepted by GontageArgs.Resize_filter (-rf).
+// They scale by 2, 3 or 4 (picked from the -sr size) and resample any remainder with bilinear,
+// in linear light when -linear is set.
+const (
+	filterScaleX = "scalex"
+	filterHqLike = "hqlike"
+	filterXbr    = "xbr"
+)
+
+func isUpscaleFilter(filter string) bool {
+	return filter == filterScaleX || filter == filterHqLike || filter == filterXbr
+}
+
+// upscaleTo runs the pixel-art upscaler filter and then fits the result to width x height.
+func upscaleTo(img image.Image, filter string, width int, height int, linear bool) image.Image {
+	bounds := img.Bounds()
+	needed := math.Max(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	if needed <= 1 {
+		// Nothing to upscale, these filters only add detail when growing.
+		return resizeRemainder(img, width, height, linear)
+	}
+	factor := min(4, max(2, int(math.Ceil(needed))))
+
+	src := toPremultiplied(img)
+	var upscaled *image.RGBA
+	switch filter {
+	case filterScaleX:
+		if factor == 3 {
+			upscaled = scale3x(src)
+		} else {
+			upscaled = scale2x(src)
+			if factor == 4 {
+				upscaled = scale2x(upscaled)
+			}
+		}
+	case filterHqLike:
+		upscaled = hqLike(src, factor)
+	default:
+		upscaled = xbr(src, factor)
+	}
+
+	upscaled_bounds := upscaled.Bounds()
+	if upscaled_bounds.Dx() == width && upscaled_bounds.Dy() == height {
+		return toNRGBA(upscaled)
+	}
+	if width%upscaled_bounds.Dx() == 0 && height%upscaled_bounds.Dy() == 0 {
+		return scaleInteger(upscaled, width/upscaled_bounds.Dx(), height/upscaled_bounds.Dy())
+	}
+	return resizeRemainder(upscaled, width, height, linear)
+}
+
+// resizeRemainder is the bilinear step after upscaling, gamma-correct with -linear like the other filters.
+func resizeRemainder(img image.Image, width int, height int, linear bool) image.Image {
+	if linear {
+		return resizeLinear(img, width, height, resize.Bilinear)
+	}
+	return resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+}
+
+// toPremultiplied copies img into a zero based RGBA image.
+// Working premultiplied means every fully transparent pixel compares equal and blends never pull in hidden colour.
+func toPremultiplied(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// clampedPixel returns the pixel at x, y, repeating the border for coordinates outside the image.
+func clampedPixel(img *image.RGBA, x int, y int) color.RGBA {
+	bounds := img.Bounds()
+	x = min(max(x, 0), bounds.Dx()-1)
+	y = min(max(y, 0), bounds.Dy()-1)
+	return img.RGBAAt(x, y)
+}
+
+// blendPixels mixes dst towards src by weight/256, like the ALPHA_BLEND macros of the reference xBR code.
+func blendPixels(dst color.RGBA, src color.RGBA, weight int) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(int(a) + (int(b)-int(a))*weight/256)
+	}
+	return color.RGBA{mix(dst.R, src.R), mix(dst.G, src.G), mix(dst.B, src.B), mix(dst.A, src.A)}
+}
+
+// weightedPixel averages colors with integer weights.
+func weightedPixel(colors []color.RGBA, weights []int) color.RGBA {
+	var r, g, b, a, total int
+	for i, c := range colors {
+		r += int(c.R) * weights[i]
+		g += int(c.G) * weights[i]
+		b += int(c.B) * weights[i]
+		a += int(c.A) * weights[i]
+		total += weights[i]
+	}
+	return color.RGBA{uint8(r / total), uint8(g / total), uint8(b / total), uint8(a / total)}
+}
+
+func yuv(c color.RGBA) (float64, float64, float64) {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	return 0.299*r + 0.587*g + 0.114*b, -0.169*r - 0.331*g + 0.5*b, 0.5*r - 0.419*g - 0.081*b
+}
+
+// pixelDistance is the weighted YUV (plus alpha) distance used by xBR.
+func pixelDistance(a color.RGBA, b color.RGBA) int {
+	ya, ua, va := yuv(a)
+	yb, ub, vb := yuv(b)
+	da := math.Abs(float64(a.A) - float64(b.A))
+	return int(48*math.Abs(ya-yb) + 7*math.Abs(ua-ub) + 6*math.Abs(va-vb) + 48*da)
+}
+
+// similarPixels applies the hqNx YUV thresholds, with alpha treated like luma.
+func similarPixels(a color.RGBA, b color.RGBA) bool {
+	if a == b {
+		return true
+	}
+	ya, ua, va := yuv(a)
+	yb, ub, vb := yuv(b)
+	return math.Abs(ya-yb) <= 48 && math.Abs(ua-ub) <= 7 && math.Abs(va-vb) <= 6 &&
+		math.Abs(float64(a.A)-float64(b.A)) <= 48
+}
+
+// scale2x is AdvMAME2x/EPX: each pixel becomes 2x2, corners copy a neighbour when an edge passes through them.
+func scale2x(src *image.RGBA) *image.RGBA {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width*2, height*2))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := src.RGBAAt(x, y)
+			a := clampedPixel(src, x, y-1)
+			b := clampedPixel(src, x+1, y)
+			c := clampedPixel(src, x-1, y)
+			d := clampedPixel(src, x, y+1)
+			e0, e1, e2, e3 := p, p, p, p
+			if c == a && c != d && a != b {
+				e0 = a
+			}
+			if a == b && a != c && b != d {
+				e1 = b
+			}
+			if d == c && d != b && c != a {
+				e2 = c
+			}
+			if b == d && b != a && d != c {
+				e3 = d
+			}
+			dst.SetRGBA(x*2, y*2, e0)
+			dst.SetRGBA(x*2+1, y*2, e1)
+			dst.SetRGBA(x*2, y*2+1, e2)
+			dst.SetRGBA(x*2+1, y*2+1, e3)
+		}
+	}
+	return dst
+}
+
+// scale3x is AdvMAME3x, the 3x3 variant of scale2x.
+func scale3x(src *image.RGBA) *image.RGBA {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width*3, height*3))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			a, b, c := clampedPixel(src, x-1, y-1), clampedPixel(src, x, y-1), clampedPixel(src, x+1, y-1)
+			d, e, f := clampedPixel(src, x-1, y), src.RGBAAt(x, y), clampedPixel(src, x+1, y)
+			g, h, i := clampedPixel(src, x-1, y+1), clampedPixel(src, x, y+1), clampedPixel(src, x+1, y+1)
+			out := [9]color.RGBA{e, e, e, e, e, e, e, e, e}
+			if b != h && d != f {
+				if d == b {
+					out[0] = d
+				}
+				if (d == b && e != c) || (b == f && e != a) {
+					out[1] = b
+				}
+				if b == f {
+					out[2] = f
+				}
+				if (d == b && e != g) || (d == h && e != a) {
+					out[3] = d
+				}
+				if (b == f && e != i) || (h == f && e != c) {
+					out[5] = f
+				}
+				if d == h {
+					out[6] = d
+				}
+				if (d == h && e != i) || (h == f && e != g) {
+					out[7] = h
+				}
+				if h == f {
+					out[8] = f
+				}
+			}
+			for n, px := range out {
+				dst.SetRGBA(x*3+n%3, y*3+n/3, px)
+			}
+		}
+	}
+	return dst
+}
+
+// cornerBlock addresses an N x N output block through one of four rotations,
+// so corner rules can always be written for the bottom-right corner.
+type cornerBlock struct {
+	pixels   []color.RGBA
+	size     int
+	rotation int
+}
+
+func (block cornerBlock) index(row int, col int) int {
+	n := block.size - 1
+	switch block.rotation {
+	case 1: // top-right corner
+		row, col = n-col, row
+	case 2: // top-left corner
+		row, col = n-row, n-col
+	case 3: // bottom-left corner
+		row, col = col, n-row
+	}
+	return row*block.size + col
+}
+
+func (block cornerBlock) get(row int, col int) color.RGBA {
+	return block.pixels[block.index(row, col)]
+}
+
+func (block cornerBlock) set(row int, col int, c color.RGBA) {
+	block.pixels[block.index(row, col)] = c
+}
+
+func (block cornerBlock) blend(row int, col int, c color.RGBA, weight int) {
+	block.set(row, col, blendPixels(block.get(row, col), c, weight))
+}
+
+// neighbourhood is the 5x5 window (minus its corners) around a source pixel, named as in the xBR reference:
+//
+//	   A1 B1 C1
+//	A0 A  B  C  C4
+//	D0 D  E  F  F4
+//	G0 G  H  I  I4
+//	   G5 H5 I5
+type neighbourhood struct {
+	A1, B1, C1, A0, A, B, C, C4, D0, D, E, F, F4, G0, G, H, I, I4, G5, H5, I5 color.RGBA
+}
+
+func neighbourhoodAt(src *image.RGBA, x int, y int) neighbourhood {
+	p := func(dx, dy int) color.RGBA { return clampedPixel(src, x+dx, y+dy) }
+	return neighbourhood{
+		A1: p(-1, -2), B1: p(0, -2), C1: p(1, -2),
+		A0: p(-2, -1), A: p(-1, -1), B: p(0, -1), C: p(1, -1), C4: p(2, -1),
+		D0: p(-2, 0), D: p(-1, 0), E: p(0, 0), F: p(1, 0), F4: p(2, 0),
+		G0: p(-2, 1), G: p(-1, 1), H: p(0, 1), I: p(1, 1), I4: p(2, 1),
+		G5: p(-1, 2), H5: p(0, 2), I5: p(1, 2),
+	}
+}
+
+// rotate turns the neighbourhood 90 degrees so the corner handled next is in the bottom-right position.
+func (nb neighbourhood) rotate() neighbourhood {
+	return neighbourhood{
+		A1: nb.G0, B1: nb.D0, C1: nb.A0,
+		A0: nb.G5, A: nb.G, B: nb.D, C: nb.A, C4: nb.A1,
+		D0: nb.H5, D: nb.H, E: nb.E, F: nb.B, F4: nb.B1,
+		G0: nb.I5, G: nb.I, H: nb.F, I: nb.C, I4: nb.C1,
+		G5: nb.I4, H5: nb.F4, I5: nb.C4,
+	}
+}
+
+// xbr is Hyllian's xBR (level 2) for 2x, 3x and 4x, blending in premultiplied alpha.
+func xbr(src *image.RGBA, factor int) *image.RGBA {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width*factor, height*factor))
+	pixels := make([]color.RGBA, factor*factor)
+	eq := func(a, b color.RGBA) bool { return pixelDistance(a, b) < 155 }
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			nb := neighbourhoodAt(src, x, y)
+			for i := range pixels {
+				pixels[i] = nb.E
+			}
+			// The reference code handles the corners in the order bottom-right, top-right, top-left, bottom-left.
+			for rotation := 0; rotation < 4; rotation++ {
+				block := cornerBlock{pixels, factor, rotation}
+				xbrCorner(block, nb, eq)
+				nb = nb.rotate()
+			}
+			for i, px := range pixels {
+				dst.SetRGBA(x*factor+i%factor, y*factor+i/factor, px)
+			}
+		}
+	}
+	return dst
+}
+
+func xbrCorner(block cornerBlock, nb neighbourhood, eq func(a, b color.RGBA) bool) {
+	if nb.E == nb.H || nb.E == nb.F {
+		return
+	}
+	df := pixelDistance
+	e := df(nb.E, nb.C) + df(nb.E, nb.G) + df(nb.I, nb.H5) + df(nb.I, nb.F4) + 4*df(nb.H, nb.F)
+	i := df(nb.H, nb.D) + df(nb.H, nb.I5) + df(nb.F, nb.I4) + df(nb.F, nb.B) + 4*df(nb.E, nb.I)
+	px := nb.H
+	if df(nb.E, nb.F) <= df(nb.E, nb.H) {
+		px = nb.F
+	}
+	n := block.size - 1
+
+	if e < i && ((!eq(nb.F, nb.B) && !eq(nb.H, nb.D)) || (eq(nb.E, nb.I) && !eq(nb.F, nb.I4) && !eq(nb.H, nb.I5)) || eq(nb.E, nb.G) || eq(nb.E, nb.C)) {
+		ke := df(nb.F, nb.G)
+		ki := df(nb.H, nb.C)
+		ex2 := nb.E != nb.C && nb.B != nb.C
+		ex3 := nb.E != nb.G && nb.D != nb.G
+		left := ke*2 <= ki && ex3
+		up := ke >= ki*2 && ex2
+		switch block.size {
+		case 2:
+			switch {
+			case left && up:
+				block.blend(1, 1, px, 224)
+				block.blend(1, 0, px, 64)
+				block.set(0, 1, block.get(1, 0))
+			case left:
+				block.blend(1, 1, px, 192)
+				block.blend(1, 0, px, 64)
+			case up:
+				block.blend(1, 1, px, 192)
+				block.blend(0, 1, px, 64)
+			default:
+				block.blend(1, 1, px, 128)
+			}
+		case 3:
+			switch {
+			case left && up:
+				block.blend(2, 1, px, 192)
+				block.blend(2, 0, px, 64)
+				block.set(1, 2, block.get(2, 1))
+				block.set(0, 2, block.get(2, 0))
+				block.set(2, 2, px)
+			case left:
+				block.blend(2, 1, px, 192)
+				block.blend(1, 2, px, 64)
+				block.blend(2, 0, px, 64)
+				block.set(2, 2, px)
+			case up:
+				block.blend(1, 2, px, 192)
+				block.blend(2, 1, px, 64)
+				block.blend(0, 2, px, 64)
+				block.set(2, 2, px)
+			default:
+				block.blend(2, 2, px, 224)
+				block.blend(1, 2, px, 32)
+				block.blend(2, 1, px, 32)
+			}
+		default:
+			switch {
+			case left && up:
+				block.blend(3, 1, px, 192)
+				block.blend(3, 0, px, 64)
+				block.set(3, 3, px)
+				block.set(3, 2, px)
+				block.set(2, 3, px)
+				block.set(2, 2, block.get(3, 0))
+				block.set(0, 3, block.get(3, 0))
+				block.set(1, 3, block.get(3, 1))
+			case left:
+				block.blend(2, 3, px, 192)
+				block.blend(3, 1, px, 192)
+				block.blend(2, 2, px, 64)
+				block.blend(3, 0, px, 64)
+				block.set(3, 2, px)
+				block.set(3, 3, px)
+			case up:
+				block.blend(3, 2, px, 192)
+				block.blend(1, 3, px, 192)
+				block.blend(2, 2, px, 64)
+				block.blend(0, 3, px, 64)
+				block.set(2, 3, px)
+				block.set(3, 3, px)
+			default:
+				block.blend(2, 3, px, 128)
+				block.blend(3, 2, px, 128)
+				block.set(3, 3, px)
+			}
+		}
+	} else if e <= i {
+		weight := 64
+		if block.size > 2 {
+			weight = 128
+		}
+		block.blend(n, n, px, weight)
+	}
+}
+
+// hqLike approximates Maxim Stepin's hqNx for N = 2, 3 and 4. It is not hqNx: it uses the same YUV
+// similarity thresholds and interpolation weights per corner, but a handful of rules instead of the
+// original 256-case lookup tables, so its output differs from real hq2x/hq3x/hq4x.
+func hqLike(src *image.RGBA, factor int) *image.RGBA {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width*factor, height*factor))
+	pixels := make([]color.RGBA, factor*factor)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			nb := neighbourhoodAt(src, x, y)
+			for i := range pixels {
+				pixels[i] = nb.E
+			}
+			for rotation := 0; rotation < 4; rotation++ {
+				hqLikeCorner(cornerBlock{pixels, factor, rotation}, nb)
+				nb = nb.rotate()
+			}
+			for i, px := range pixels {
+				dst.SetRGBA(x*factor+i%factor, y*factor+i/factor, px)
+			}
+		}
+	}
+	return dst
+}
+
+func hqLikeCorner(block cornerBlock, nb neighbourhood) {
+	e, f, h, i := nb.E, nb.F, nb.H, nb.I
+	diff_f := !similarPixels(e, f)
+	diff_h := !similarPixels(e, h)
+	diff_i := !similarPixels(e, i)
+	n := block.size - 1
+	mix := func(weights ...int) color.RGBA {
+		return weightedPixel([]color.RGBA{e, f, h}, weights)
+	}
+
+	var corner, side_f, side_h color.RGBA
+	switch {
+	case diff_f && diff_h && similarPixels(f, h):
+		// A diagonal edge cuts through this corner.
+		corner, side_f, side_h = mix(2, 3, 3), mix(6, 1, 1), mix(6, 1, 1)
+		if block.size > 2 && !diff_i {
+			// The edge is a thin line, keep it thin.
+			corner = mix(6, 1, 1)
+		}
+		if block.size > 2 && similarPixels(f, i) {
+			corner = weightedPixel([]color.RGBA{e, f, h}, []int{1, 1, 1})
+			if block.size == 4 {
+				corner = weightedPixel([]color.RGBA{f, h}, []int{1, 1})
+			}
+			side_f, side_h = mix(3, 1, 0), mix(3, 0, 1)
+		}
+	case diff_f && diff_h:
+		corner, side_f, side_h = mix(2, 1, 1), mix(7, 1, 0), mix(7, 0, 1)
+	case diff_f:
+		corner, side_f, side_h = mix(3, 1, 0), mix(7, 1, 0), e
+	case diff_h:
+		corner, side_f, side_h = mix(3, 0, 1), e, mix(7, 0, 1)
+	case diff_i:
+		corner, side_f, side_h = weightedPixel([]color.RGBA{e, i}, []int{7, 1}), e, e
+	default:
+		return
+	}
+
+	block.set(n, n, corner)
+	if block.size > 2 {
+		// The pixels next to the corner along each edge take a softer version of the same blend.
+		hqLikeSide(block, n-1, n, side_f, e)
+		hqLikeSide(block, n, n-1, side_h, e)
+	}
+}
+
+// hqLikeSide sets an edge pixel next to a corner. At 3x the middle of each edge sits next to two
+// corners, so a second blend is averaged with the first rather than replacing it, which keeps the
+// result the same whichever corner is handled first.
+func hqLikeSide(block cornerBlock, row int, col int, c color.RGBA, e color.RGBA) {
+	if c == e {
+		return
+	}
+	if current := block.get(row, col); current != e {
+		c = weightedPixel([]color.RGBA{current, c}, []int{1, 1})
+	}
+	block.set(row, col, c)
+}
@@ -0,0 +1,157 @@
+package gontage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+// upscaleTestDiagonal is a 2x2 red diagonal. The transparent pixels hide green, which must never
+// bleed into the upscaled edges.
+func upscaleTestDiagonal() *image.NRGBA {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	src.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{0, 255, 0, 0})
+	src.SetNRGBA(0, 1, color.NRGBA{0, 255, 0, 0})
+	src.SetNRGBA(1, 1, color.NRGBA{255, 0, 0, 255})
+	return src
+}
+
+// checkUpscaledAlpha compares the alpha of every pixel with want, one row per entry,
+// and checks every visible pixel is still pure red.
+func checkUpscaledAlpha(t *testing.T, name string, img *image.NRGBA, want [][]uint8) {
+	t.Helper()
+	if size := img.Bounds().Size(); size != image.Pt(len(want[0]), len(want)) {
+		t.Fatalf("%s: size %v, want %dx%d", name, size, len(want[0]), len(want))
+	}
+	for y, row := range want {
+		for x, alpha := range row {
+			got := img.NRGBAAt(x, y)
+			if got.A != alpha {
+				t.Errorf("%s: pixel %d,%d alpha %d, want %d", name, x, y, got.A, alpha)
+			}
+			if got.A > 0 && (got.R != 255 || got.G != 0 || got.B != 0) {
+				t.Errorf("%s: pixel %d,%d = %v, want red", name, x, y, got)
+			}
+		}
+	}
+}
+
+func TestUpscaleFilters(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		size   int
+		want   [][]uint8
+	}{
+		{filterScaleX, 4, [][]uint8{
+			{255, 255, 0, 0},
+			{255, 0, 255, 0},
+			{0, 255, 0, 255},
+			{0, 0, 255, 255},
+		}},
+		{filterScaleX, 6, [][]uint8{
+			{255, 255, 255, 0, 0, 0},
+			{255, 255, 0, 255, 0, 0},
+			{255, 0, 0, 255, 255, 0},
+			{0, 255, 255, 0, 0, 255},
+			{0, 0, 255, 0, 255, 255},
+			{0, 0, 0, 255, 255, 255},
+		}},
+		{filterXbr, 4, [][]uint8{
+			{255, 255, 0, 0},
+			{255, 192, 63, 0},
+			{0, 63, 192, 255},
+			{0, 0, 255, 255},
+		}},
+		{filterXbr, 6, [][]uint8{
+			{255, 255, 255, 0, 0, 0},
+			{255, 255, 255, 0, 0, 0},
+			{255, 255, 128, 127, 0, 0},
+			{0, 0, 127, 128, 255, 255},
+			{0, 0, 0, 255, 255, 255},
+			{0, 0, 0, 255, 255, 255},
+		}},
+		{filterHqLike, 4, [][]uint8{
+			{255, 191, 63, 0},
+			{191, 63, 191, 63},
+			{63, 191, 63, 191},
+			{0, 63, 191, 255},
+		}},
+		{filterHqLike, 6, [][]uint8{
+			{255, 255, 191, 63, 0, 0},
+			{255, 255, 207, 47, 0, 0},
+			{191, 207, 191, 63, 47, 63},
+			{63, 47, 63, 191, 207, 191},
+			{0, 0, 47, 207, 255, 255},
+			{0, 0, 63, 191, 255, 255},
+		}},
+	} {
+		upscaled := toNRGBA(upscaleTo(upscaleTestDiagonal(), tc.filter, tc.size, tc.size, false))
+		checkUpscaledAlpha(t, tc.filter, upscaled, tc.want)
+	}
+}
+
+func TestUpscaleFiltersAreSymmetric(t *testing.T) {
+	// The diagonal is its own mirror image, so the upscaled result must be too, whichever order the
+	// corners of each block are handled in.
+	for _, filter := range []string{filterScaleX, filterXbr, filterHqLike} {
+		for _, size := range []int{4, 6, 8} {
+			upscaled := toNRGBA(upscaleTo(upscaleTestDiagonal(), filter, size, size, false))
+			for y := 0; y < size; y++ {
+				for x := 0; x < y; x++ {
+					if a, b := upscaled.NRGBAAt(x, y), upscaled.NRGBAAt(y, x); a != b {
+						t.Errorf("%s %dx: pixel %d,%d = %v but %d,%d = %v", filter, size/2, x, y, a, y, x, b)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestUpscaleFlatImage(t *testing.T) {
+	flat := solidTestImage(3, 2, color.NRGBA{200, 200, 200, 200})
+	for _, filter := range []string{filterScaleX, filterXbr, filterHqLike} {
+		upscaled := toNRGBA(upscaleTo(flat, filter, 9, 6, false))
+		for i, v := range upscaled.Pix {
+			if v != 200 {
+				t.Fatalf("%s: byte %d = %d, want a flat 200", filter, i, v)
+			}
+		}
+	}
+}
+
+func TestUpscaleRemainderKeepsEdgeColour(t *testing.T) {
+	// 5x5 upscales by 3 and then resamples the remainder, the resampled edges must stay red as well.
+	for _, filter := range []string{filterScaleX, filterXbr, filterHqLike} {
+		for _, linear := range []bool{false, true} {
+			upscaled := toNRGBA(upscaleTo(upscaleTestDiagonal(), filter, 5, 5, linear))
+			if size := upscaled.Bounds().Size(); size != image.Pt(5, 5) {
+				t.Fatalf("%s: size %v, want 5x5", filter, size)
+			}
+			for i := 0; i < len(upscaled.Pix); i += 4 {
+				px := upscaled.Pix[i : i+4]
+				if px[3] > 0 && (px[0] < 254 || px[1] > 1 || px[2] > 1) {
+					t.Errorf("%s linear %v: pixel %d = %v, want red", filter, linear, i/4, px)
+				}
+			}
+		}
+	}
+}
+
+func TestUpscaleRemainderHonoursLinear(t *testing.T) {
+	// -linear resamples the remainder in linear light, the same as resizeLinear would.
+	gargs := GontageArgs{Resize_filter: filterScaleX, Linear_resize: true}
+	got := toNRGBA(resizeTo(upscaleTestDiagonal(), gargs, 5, 5))
+	want := resizeLinear(scale3x(toPremultiplied(upscaleTestDiagonal())), 5, 5, resize.Bilinear)
+	for i := range want.Pix {
+		if got.Pix[i] != want.Pix[i] {
+			t.Fatalf("byte %d = %d, want %d from a linear light resample", i, got.Pix[i], want.Pix[i])
+		}
+	}
+	gargs.Linear_resize = false
+	if gamma := toNRGBA(resizeTo(upscaleTestDiagonal(), gargs, 5, 5)); string(gamma.Pix) == string(want.Pix) {
+		t.Errorf("-linear made no difference to the remainder")
+	}
+}